@@ -134,9 +134,22 @@ document.addEventListener('DOMContentLoaded', () => {
             clientProcess.stdin.write(message + '\n');
             addMessage(currentUser, message, 'user');
             elements.messageInput.value = '';
+            lastTypingSignal = 0;
         }
     };
 
+    // Сигнал набора текста. stdin клиента — канал, а не терминал, поэтому
+    // клиент не видит набор до Enter; о нём сообщает строка /typing
+    let lastTypingSignal = 0;
+    elements.messageInput.addEventListener('input', () => {
+        const text = elements.messageInput.value.trim();
+        if (!clientProcess || !text || text.startsWith('/')) return;
+        const now = Date.now();
+        if (now - lastTypingSignal < 3000) return;
+        lastTypingSignal = now;
+        clientProcess.stdin.write('/typing\n');
+    });
+
     elements.sendBtn.addEventListener('click', sendMessage);
     elements.messageInput.addEventListener('keypress', (e) => {
         if (e.key === 'Enter') sendMessage();
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"airchat/protocol"
+)
+
+var (
+	nameMu   sync.Mutex
+	username string // имя в чате; сервер меняет его сообщением RENAME
+
+	// Данные последнего успешного входа: с ними клиент входит снова,
+	// если после переподключения сервер начал новую сессию
+	authMu      sync.Mutex
+	pendingAuth [2]string // имя и пароль отправленного REGISTER/LOGIN
+	credentials [2]string
+)
+
+func currentName() string {
+	nameMu.Lock()
+	defer nameMu.Unlock()
+	return username
+}
+
+func setName(name string) {
+	nameMu.Lock()
+	username = name
+	nameMu.Unlock()
+}
+
+// authCommand отправляет REGISTER или LOGIN с аргументами "<имя> <пароль>"
+func authCommand(conn *controlConn, t protocol.Type, command, arg string) {
+	name, password, _ := strings.Cut(arg, " ")
+	if name == "" || password == "" {
+		fmt.Printf("Использование: %s <имя> <пароль>\n", command)
+		return
+	}
+	if !conn.stream {
+		fmt.Println("⚠️ Пароль передаётся без шифрования, используйте -tls")
+	}
+	authMu.Lock()
+	pendingAuth = [2]string{name, password}
+	authMu.Unlock()
+	if err := sendControl(conn, protocol.New(t, name, password)); err != nil {
+		fmt.Println("Ошибка отправки:", err)
+	}
+}
+
+func savedCredentials() (name, password string, ok bool) {
+	authMu.Lock()
+	defer authMu.Unlock()
+	return credentials[0], credentials[1], credentials[0] != ""
+}
+
+// handleRename обновляет имя участника: [старое, новое]. Если старое
+// имя наше, сервер сменил его по /nick, при входе или из-за дубликата.
+func handleRename(msg protocol.Message) {
+	old, name := msg.Field(0), msg.Field(1)
+	renameIdentity(old, name)
+	renamePresence(old, name)
+	if old == currentName() {
+		setName(name)
+		fmt.Printf("\rТеперь вы известны как %s\n%s", name, prompt())
+		return
+	}
+	fmt.Printf("\r%s теперь известен как %s\n%s", old, name, prompt())
+}
+
+// handleAuthOK сообщает о входе; если имя в чате меняется,
+// сервер отдельно присылает RENAME
+func handleAuthOK(msg protocol.Message) {
+	authMu.Lock()
+	if pendingAuth[0] == msg.Field(0) {
+		credentials = pendingAuth
+	}
+	authMu.Unlock()
+	fmt.Printf("\r✅ Вы вошли как %s\n%s", msg.Field(0), prompt())
+}
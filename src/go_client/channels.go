@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"airchat/protocol"
+)
+
+var errNoChannel = errors.New("вы не в канале, войдите: /join #канал")
+
+// defaultChannel — канал, в который сервер помещает каждого нового участника
+const defaultChannel = "#general"
+
+var (
+	channelMu sync.Mutex
+	joined    []string // каналы, в которых состоит пользователь, в порядке входа
+	current   string   // канал, в который уходят сообщения; пусто — ни одного
+	rejoining bool     // сессия не первая: набор каналов восстанавливается
+)
+
+// currentChannel возвращает канал, в который уходят сообщения
+func currentChannel() string {
+	channelMu.Lock()
+	defer channelMu.Unlock()
+	return current
+}
+
+// prompt возвращает приглашение ввода с текущим каналом, подсказкой,
+// кто в нём печатает, и уже набранной частью строки
+func prompt() string {
+	if channel := currentChannel(); channel != "" {
+		return channel + typingLabel(channel) + "> " + typedText()
+	}
+	return "> " + typedText()
+}
+
+// channelArg дополняет имя канала из команды символом '#'
+func channelArg(name string) string {
+	if name != "" && !strings.HasPrefix(name, "#") {
+		return "#" + name
+	}
+	return name
+}
+
+// handleChannelJoin запоминает канал [канал, участники...] и делает его текущим
+func handleChannelJoin(msg protocol.Message) {
+	name := msg.Field(0)
+
+	channelMu.Lock()
+	if !slices.Contains(joined, name) {
+		joined = append(joined, name)
+	}
+	current = name
+	channelMu.Unlock()
+
+	fmt.Printf("\rВы в канале %s, участники: %s\n%s", name, strings.Join(msg.Fields[1:], ", "), prompt())
+}
+
+// handleChannelPart забывает канал; текущим становится последний
+// из оставшихся
+func handleChannelPart(msg protocol.Message) {
+	name := msg.Field(0)
+
+	channelMu.Lock()
+	if i := slices.Index(joined, name); i >= 0 {
+		joined = slices.Delete(joined, i, i+1)
+	}
+	if current == name {
+		current = ""
+		if len(joined) > 0 {
+			current = joined[len(joined)-1]
+		}
+	}
+	channelMu.Unlock()
+
+	fmt.Printf("\rВы покинули %s\n%s", name, prompt())
+}
+
+// handleChannels выводит список текстовых каналов
+func handleChannels(msg protocol.Message) {
+	printChannelList("Каналы", msg, currentChannel())
+}
+
+// handleVoiceList выводит список голосовых каналов
+func handleVoiceList(msg protocol.Message) {
+	printChannelList("Голосовые каналы", msg, currentVoiceChannel())
+}
+
+// printChannelList выводит список [канал, участники через пробел, ...],
+// отмечая канал own звёздочкой
+func printChannelList(title string, msg protocol.Message, own string) {
+	if len(msg.Fields) == 0 {
+		fmt.Printf("\r%s: пусто\n%s", title, prompt())
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "\r%s:\n", title)
+	for i := 0; i+1 < len(msg.Fields); i += 2 {
+		mark := " "
+		if msg.Fields[i] == own {
+			mark = "*"
+		}
+		members := strings.Fields(msg.Fields[i+1])
+		for j, member := range members {
+			members[j] = member + presenceMark(member)
+		}
+		if len(members) == 0 {
+			fmt.Fprintf(&b, "%s %s (пусто)\n", mark, msg.Fields[i])
+			continue
+		}
+		fmt.Fprintf(&b, "%s %s (%d): %s\n", mark, msg.Fields[i], len(members), strings.Join(members, ", "))
+	}
+	fmt.Print(b.String() + prompt())
+}
+
+// rejoinChannels возвращает пользователя в его каналы после начала
+// новой сессии: сервер помещает новых участников только в канал
+// по умолчанию. Если пользователь его покинул, канал покидается снова.
+// Текущим остаётся прежний канал.
+func rejoinChannels(conn *controlConn) error {
+	channelMu.Lock()
+	previous, was, again := append([]string(nil), joined...), current, rejoining
+	joined, current, rejoining = nil, "", true
+	channelMu.Unlock()
+
+	if again && !slices.Contains(previous, defaultChannel) {
+		if err := sendControl(conn, protocol.New(protocol.TypeChannelPart, defaultChannel)); err != nil {
+			return err
+		}
+	}
+	for _, name := range previous {
+		if name != was {
+			if err := sendControl(conn, protocol.New(protocol.TypeChannelJoin, name)); err != nil {
+				return err
+			}
+		}
+	}
+	if was != "" {
+		return sendControl(conn, protocol.New(protocol.TypeChannelJoin, was))
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	"airchat/protocol"
+)
+
+// sendDirect отправляет личное сообщение; при сквозном шифровании текст
+// шифруется нашим ключом отправителя, который получатель уже знает
+func sendDirect(conn *controlConn, to, text string) error {
+	data, id, err := sealText(text)
+	if err != nil {
+		return err
+	}
+	return sendControl(conn, protocol.New(protocol.TypeDirect, to, data, id))
+}
+
+// handleDirect выводит личное сообщение [отправитель, текст, id ключа]
+func handleDirect(msg protocol.Message) {
+	sender, text, id := msg.Field(0), msg.Field(1), msg.Field(2)
+	if id != "" {
+		var ok bool
+		if text, ok = openSealed(sender, id, []byte(text)); !ok {
+			return
+		}
+	}
+	fmt.Printf("\r✉️ [%s → вам]: %s\n%s", sender, text, prompt())
+}
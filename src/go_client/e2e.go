@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"airchat/protocol"
+)
+
+var identityFile = flag.String("identity", defaultIdentityFile(),
+	"файл ключа идентичности X25519 (создаётся при первом запуске)")
+
+type chatKey struct {
+	username string
+	key      []byte
+}
+
+var (
+	identity *ecdh.PrivateKey // долговременный ключ идентичности
+
+	e2eMu      sync.Mutex
+	chatE2E    bool                   // сервер согласовал сквозное шифрование чата
+	ownChatID  string                 // идентификатор нашего ключа отправителя
+	ownChatKey []byte                 // наш ключ отправителя
+	identities = map[string][]byte{}  // ключи идентичности участников по имени
+	chatKeys   = map[string]chatKey{} // ключи отправителей по идентификатору
+)
+
+func defaultIdentityFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "airchat_identity.key"
+	}
+	return filepath.Join(dir, "airchat", "identity.key")
+}
+
+// loadIdentity читает ключ идентичности из файла или создаёт новый
+func loadIdentity(path string) (*ecdh.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return ecdh.X25519().NewPrivateKey(data)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, priv.Bytes(), 0o600); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Создан ключ идентичности: %s\n", path)
+	return priv, nil
+}
+
+// configureChatE2E включает или выключает сквозное шифрование чата
+// для новой сессии. Каждая сессия получает новый ключ отправителя.
+func configureChatE2E(enabled bool) error {
+	var id string
+	var key []byte
+	if enabled {
+		var err error
+		if id, key, err = protocol.NewChatKey(); err != nil {
+			return fmt.Errorf("ошибка создания ключа чата: %w", err)
+		}
+		fmt.Println("🔒 Сообщения шифруются сквозным ключом")
+	}
+	e2eMu.Lock()
+	chatE2E, ownChatID, ownChatKey = enabled, id, key
+	e2eMu.Unlock()
+	return nil
+}
+
+// sendChat отправляет сообщение в канал открытым текстом или, если
+// согласовано сквозное шифрование, зашифрованным нашим ключом отправителя
+func sendChat(conn *controlConn, channel, text string) error {
+	if channel == "" {
+		return errNoChannel
+	}
+	data, id, err := sealText(text)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		return sendControl(conn, protocol.New(protocol.TypeChat, text, channel))
+	}
+	return sendControl(conn, protocol.New(protocol.TypeSealedChat, id, data, channel))
+}
+
+// sendReply отправляет ответ на сообщение parent; сервер помещает его
+// в канал родительского сообщения
+func sendReply(conn *controlConn, parent, text string) error {
+	parent, err := messageID(parent)
+	if err != nil {
+		return err
+	}
+	data, id, err := sealText(text)
+	if err != nil {
+		return err
+	}
+	if id == "" {
+		return sendControl(conn, protocol.New(protocol.TypeChat, text, currentChannel(), parent))
+	}
+	return sendControl(conn, protocol.New(protocol.TypeSealedChat, id, data, currentChannel(), parent))
+}
+
+// sealText шифрует текст нашим ключом отправителя, если согласовано
+// сквозное шифрование, и возвращает шифротекст с id ключа. Без E2E
+// текст возвращается как есть с пустым id.
+func sealText(text string) (data, id string, err error) {
+	e2eMu.Lock()
+	enabled, id, key := chatE2E, ownChatID, ownChatKey
+	e2eMu.Unlock()
+
+	if !enabled {
+		return text, "", nil
+	}
+	sealed, err := protocol.SealChat(key, id, text)
+	if err != nil {
+		return "", "", err
+	}
+	return string(sealed), id, nil
+}
+
+// handleIdentity запоминает ключ участника и в режиме E2E передаёт
+// ему наш ключ отправителя, зашифрованный для его ключа идентичности
+func handleIdentity(conn *controlConn, msg protocol.Message) {
+	name, pub := msg.Field(0), []byte(msg.Field(1))
+	if _, err := protocol.ParseIdentityKey(pub); err != nil {
+		return
+	}
+
+	e2eMu.Lock()
+	if old, ok := identities[name]; ok && !bytes.Equal(old, pub) {
+		fmt.Printf("\r⚠️ Ключ идентичности %s изменился, сверьте его: /verify %s\n%s", name, name, prompt())
+	}
+	identities[name] = pub
+	enabled, id, key := chatE2E, ownChatID, ownChatKey
+	e2eMu.Unlock()
+
+	if !enabled {
+		return
+	}
+	sealed, err := protocol.SealChatKey(identity, pub, key)
+	if err != nil {
+		fmt.Printf("\r❌ Не удалось передать ключ чата %s: %v\n%s", name, err, prompt())
+		return
+	}
+	sendControl(conn, protocol.New(protocol.TypeGroupKey, name, id, string(sealed)))
+}
+
+// handleGroupKey расшифровывает ключ отправителя, полученный от участника
+func handleGroupKey(msg protocol.Message) {
+	name, id := msg.Field(0), msg.Field(1)
+
+	e2eMu.Lock()
+	defer e2eMu.Unlock()
+
+	pub, ok := identities[name]
+	if !ok {
+		return
+	}
+	key, err := protocol.OpenChatKey(identity, pub, []byte(msg.Field(2)))
+	if err != nil {
+		fmt.Printf("\r❌ Не удалось расшифровать ключ чата %s: %v\n%s", name, err, prompt())
+		return
+	}
+	chatKeys[id] = chatKey{username: name, key: key}
+}
+
+// handleSealedChat расшифровывает сообщение
+// [отправитель, id ключа, шифротекст, канал, id, время, родитель...]
+func handleSealedChat(msg protocol.Message) {
+	sender := msg.Field(0)
+	noteLive(msg.Field(3), msg.Field(4))
+	if text, ok := openSealed(sender, msg.Field(1), []byte(msg.Field(2))); ok {
+		printChat(sender, text, msg.Field(3), msg.Field(4), protocol.ParseExcerpt(msg.Fields[min(6, len(msg.Fields)):]))
+	}
+}
+
+// openSealed расшифровывает сообщение sender ключом отправителя id.
+// При ошибке выводит предупреждение и возвращает false.
+func openSealed(sender, id string, sealed []byte) (string, bool) {
+	text, owner, err := decryptSealed(id, sealed)
+	if errors.Is(err, errUnknownChatKey) {
+		fmt.Printf("\r🔒 Сообщение от %s зашифровано неизвестным ключом\n%s", sender, prompt())
+		return "", false
+	}
+	if err != nil {
+		fmt.Printf("\r❌ Не удалось расшифровать сообщение от %s: %v\n%s", sender, err, prompt())
+		return "", false
+	}
+	if owner != sender {
+		// Сервер приписал сообщение не владельцу ключа
+		fmt.Printf("\r⚠️ Сообщение от %s зашифровано ключом %s\n%s", sender, owner, prompt())
+	}
+	return text, true
+}
+
+var errUnknownChatKey = errors.New("неизвестный ключ отправителя")
+
+// decryptSealed расшифровывает сообщение ключом отправителя id
+// и возвращает текст и имя владельца ключа
+func decryptSealed(id string, sealed []byte) (text, owner string, err error) {
+	e2eMu.Lock()
+	ck, ok := chatKeys[id]
+	own := id != "" && id == ownChatID
+	if own {
+		// Сервер возвращает нам наши же сообщения
+		ck, ok = chatKey{key: ownChatKey}, true
+	}
+	e2eMu.Unlock()
+	if own {
+		ck.username = currentName()
+	}
+
+	if !ok {
+		return "", "", errUnknownChatKey
+	}
+	text, err = protocol.OpenChat(ck.key, id, sealed)
+	return text, ck.username, err
+}
+
+// identityOf возвращает известный ключ идентичности участника
+func identityOf(name string) ([]byte, bool) {
+	e2eMu.Lock()
+	defer e2eMu.Unlock()
+	pub, ok := identities[name]
+	return pub, ok
+}
+
+// renameIdentity переносит известные ключи участника на его новое имя
+func renameIdentity(old, name string) {
+	e2eMu.Lock()
+	defer e2eMu.Unlock()
+
+	if pub, ok := identities[old]; ok {
+		delete(identities, old)
+		identities[name] = pub
+	}
+	for id, ck := range chatKeys {
+		if ck.username == old {
+			ck.username = name
+			chatKeys[id] = ck
+		}
+	}
+}
+
+// verifyIdentity выводит отпечатки ключей для сверки по независимому каналу
+func verifyIdentity(name string) {
+	fmt.Printf("Ваш отпечаток:   %s\n", protocol.Fingerprint(identity.PublicKey().Bytes()))
+	if name == "" {
+		return
+	}
+
+	e2eMu.Lock()
+	pub, ok := identities[name]
+	e2eMu.Unlock()
+	if !ok {
+		fmt.Printf("Ключ %s неизвестен\n", name)
+		return
+	}
+	fmt.Printf("Отпечаток %s: %s\n", name, protocol.Fingerprint(pub))
+}
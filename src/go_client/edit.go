@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"airchat/protocol"
+)
+
+// messageID разбирает идентификатор сообщения из команды;
+// допускается метка, как её показывает чат: №12
+func messageID(s string) (string, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "№"), "#")
+	if _, err := protocol.ParseID(s); err != nil {
+		return "", errors.New("некорректный идентификатор сообщения: " + s)
+	}
+	return s, nil
+}
+
+// sendEdit просит сервер заменить текст сообщения; при сквозном
+// шифровании новый текст шифруется нашим ключом отправителя
+func sendEdit(conn *controlConn, id, text string) error {
+	id, err := messageID(id)
+	if err != nil {
+		return err
+	}
+	data, keyID, err := sealText(text)
+	if err != nil {
+		return err
+	}
+	return sendControl(conn, protocol.New(protocol.TypeEdit, id, data, keyID))
+}
+
+// sendDelete просит сервер удалить сообщение
+func sendDelete(conn *controlConn, id string) error {
+	id, err := messageID(id)
+	if err != nil {
+		return err
+	}
+	return sendControl(conn, protocol.New(protocol.TypeDelete, id))
+}
+
+// handleEdit выводит новый текст сообщения [канал, id, кто изменил, текст, id ключа]
+func handleEdit(msg protocol.Message) {
+	channel, id, editor, text, keyID := msg.Field(0), msg.Field(1), msg.Field(2), msg.Field(3), msg.Field(4)
+	if keyID != "" {
+		var ok bool
+		if text, ok = openSealed(editor, keyID, []byte(text)); !ok {
+			return
+		}
+	}
+	printInChannel(channel, fmt.Sprintf("✏️ %s[%s] изменил сообщение: %s", messageLabel(id), editor, text))
+}
+
+// handleDelete сообщает об удалении сообщения [канал, id, кто удалил]
+func handleDelete(msg protocol.Message) {
+	channel, id, by := msg.Field(0), msg.Field(1), msg.Field(2)
+	printInChannel(channel, fmt.Sprintf("🗑️ %s[%s] удалил сообщение", messageLabel(id), by))
+}
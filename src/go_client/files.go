@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"airchat/protocol"
+)
+
+var downloadDir = flag.String("downloads", ".", "каталог для принятых файлов")
+
+// fileStallTimeout — через сколько без новых частей файла получатель
+// повторяет запрос с текущего смещения
+const fileStallTimeout = 10 * time.Second
+
+// outgoingFile — файл, который мы предложили
+type outgoingFile struct {
+	path  string
+	offer protocol.FileOffer
+}
+
+// download — принимаемый файл; части пишутся во временный файл (см. partPath)
+type download struct {
+	offer  protocol.FileOffer
+	file   *os.File
+	offset int64 // сколько байт уже записано
+	end    int64 // до какого смещения запрошены части
+	timer  *time.Timer
+}
+
+var (
+	filesMu   sync.Mutex
+	offered   = map[string]*outgoingFile{}      // наши предложения по id
+	offers    = map[string]protocol.FileOffer{} // полученные предложения по id
+	downloads = map[string]*download{}
+)
+
+// formatSize показывает размер файла в удобных единицах
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f МБ", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f КБ", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d байт", n)
+}
+
+// fileSum считает SHA-256 файла
+func fileSum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sendFile предлагает файл пользователю или каналу (#канал)
+func sendFile(conn *controlConn, target, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return errors.New(path + " не является файлом")
+	}
+	if info.Size() == 0 {
+		return errors.New("файл пуст")
+	}
+	sum, err := fileSum(path)
+	if err != nil {
+		return err
+	}
+	id, err := protocol.NewTransferID()
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(target, "#") {
+		target = channelArg(target)
+	}
+	offer := protocol.FileOffer{ID: id, Target: target, Name: filepath.Base(path), Size: info.Size(), Sum: sum}
+
+	filesMu.Lock()
+	offered[id] = &outgoingFile{path: path, offer: offer}
+	filesMu.Unlock()
+
+	if err := sendControl(conn, offer.Message()); err != nil {
+		return err
+	}
+	fmt.Printf("📤 Файл %s (%s) предложен %s, передача %s\n", offer.Name, formatSize(offer.Size), target, id)
+	return nil
+}
+
+// handleFileOffer сообщает о предложенном нам файле
+func handleFileOffer(msg protocol.Message) {
+	offer, err := protocol.ParseFileOffer(msg)
+	if err != nil {
+		return
+	}
+	filesMu.Lock()
+	offers[offer.ID] = offer
+	filesMu.Unlock()
+
+	to := "вам"
+	if strings.HasPrefix(offer.Target, "#") {
+		to = offer.Target
+	}
+	fmt.Printf("\r📥 %s предлагает %s файл %s (%s), принять: /accept %s\n%s",
+		offer.Sender, to, offer.Name, formatSize(offer.Size), offer.ID, prompt())
+}
+
+// acceptFile начинает или продолжает приём файла: если часть файла
+// уже принята раньше, запрос начинается с её конца
+func acceptFile(id string) error {
+	filesMu.Lock()
+	defer filesMu.Unlock()
+
+	if _, ok := downloads[id]; ok {
+		return errors.New("файл " + id + " уже принимается")
+	}
+	offer, ok := offers[id]
+	if !ok {
+		return errors.New("предложение " + id + " не найдено")
+	}
+	if err := os.MkdirAll(*downloadDir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(partPath(offer), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	offset := info.Size()
+	if offset > offer.Size {
+		// Файл длиннее предложенного: принимаем заново
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return err
+		}
+		offset = 0
+	}
+
+	d := &download{offer: offer, file: f, offset: offset}
+	d.timer = time.AfterFunc(fileStallTimeout, func() { retryDownload(id) })
+	downloads[id] = d
+	if offset > 0 {
+		fmt.Printf("⏯️ Продолжаем приём %s с %s\n", offer.Name, formatSize(offset))
+	}
+	return requestChunks(d)
+}
+
+// partPath — файл, куда пишутся принятые части
+func partPath(offer protocol.FileOffer) string {
+	return filepath.Join(*downloadDir, filepath.Base(offer.Name)+"."+offer.ID+".part")
+}
+
+// requestChunks запрашивает у отправителя следующее окно частей.
+// Вызывающий должен держать filesMu.
+func requestChunks(d *download) error {
+	d.end = min(d.offset+protocol.FileWindow*protocol.FileChunkSize, d.offer.Size)
+	d.timer.Reset(fileStallTimeout)
+	conn := activeConn()
+	if conn == nil {
+		// Повторим, когда связь восстановится
+		return nil
+	}
+	return sendControl(conn, protocol.New(protocol.TypeFileAccept, d.offer.ID, strconv.FormatInt(d.offset, 10)))
+}
+
+// retryDownload повторяет запрос, если части перестали приходить:
+// сообщения потерялись или отправитель переподключился
+func retryDownload(id string) {
+	filesMu.Lock()
+	defer filesMu.Unlock()
+	if d, ok := downloads[id]; ok {
+		requestChunks(d)
+	}
+}
+
+// handleFileChunk записывает принятую часть файла
+func handleFileChunk(msg protocol.Message) {
+	chunk, err := protocol.ParseFileChunk(msg)
+	if err != nil {
+		return
+	}
+	filesMu.Lock()
+	defer filesMu.Unlock()
+
+	d, ok := downloads[chunk.ID]
+	if !ok || chunk.Offset != d.offset || chunk.Peer != d.offer.Sender {
+		// Повтор или часть из устаревшего запроса
+		return
+	}
+	data := chunk.Data
+	if chunk.KeyID != "" {
+		text, owner, err := decryptSealed(chunk.KeyID, data)
+		if err != nil || owner != d.offer.Sender {
+			fmt.Printf("\r❌ Не удалось расшифровать часть файла %s\n%s", d.offer.Name, prompt())
+			return
+		}
+		data = []byte(text)
+	}
+	if protocol.ChunkSum(data) != chunk.Sum || d.offset+int64(len(data)) > d.offer.Size {
+		// Запрашиваем заново с того же места
+		fmt.Printf("\r⚠️ Повреждённая часть файла %s, повторный запрос\n%s", d.offer.Name, prompt())
+		requestChunks(d)
+		return
+	}
+	if _, err := d.file.WriteAt(data, d.offset); err != nil {
+		fmt.Printf("\r❌ Ошибка записи файла %s: %v\n%s", d.offer.Name, err, prompt())
+		finishDownload(d, false)
+		return
+	}
+	d.offset += int64(len(data))
+
+	switch {
+	case d.offset == d.offer.Size:
+		finishDownload(d, true)
+	case d.offset >= d.end:
+		requestChunks(d)
+	}
+}
+
+// finishDownload закрывает принятый файл; если он принят полностью,
+// проверяет SHA-256 и переименовывает его. Вызывающий должен держать filesMu.
+func finishDownload(d *download, complete bool) {
+	d.timer.Stop()
+	d.file.Close()
+	delete(downloads, d.offer.ID)
+	if !complete {
+		return
+	}
+
+	part := partPath(d.offer)
+	sum, err := fileSum(part)
+	if err != nil || sum != d.offer.Sum {
+		os.Remove(part)
+		fmt.Printf("\r❌ Файл %s повреждён (не совпала контрольная сумма), примите его заново\n%s",
+			d.offer.Name, prompt())
+		return
+	}
+	path := uniquePath(filepath.Join(*downloadDir, filepath.Base(d.offer.Name)))
+	if err := os.Rename(part, path); err != nil {
+		fmt.Printf("\r❌ Ошибка сохранения файла %s: %v\n%s", d.offer.Name, err, prompt())
+		return
+	}
+	delete(offers, d.offer.ID)
+	fmt.Printf("\r✅ Файл от %s сохранён: %s\n%s", d.offer.Sender, path, prompt())
+
+	// Сообщаем отправителю, что всё получено
+	if conn := activeConn(); conn != nil {
+		sendControl(conn, protocol.New(protocol.TypeFileAccept, d.offer.ID, strconv.FormatInt(d.offer.Size, 10)))
+	}
+}
+
+// uniquePath добавляет к имени номер, если файл уже существует
+func uniquePath(path string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return path
+		}
+		path = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+// handleFileAccept отправляет получателю запрошенные части файла
+// [id, получатель, смещение]
+func handleFileAccept(conn *controlConn, msg protocol.Message) {
+	id, recipient := msg.Field(0), msg.Field(1)
+	offset, err := strconv.ParseInt(msg.Field(2), 10, 64)
+	if err != nil {
+		return
+	}
+	filesMu.Lock()
+	out, ok := offered[id]
+	filesMu.Unlock()
+	if !ok {
+		return
+	}
+	if offset == out.offer.Size {
+		fmt.Printf("\r✅ %s получил файл %s\n%s", recipient, out.offer.Name, prompt())
+		return
+	}
+	if offset == 0 {
+		fmt.Printf("\r📤 %s принимает файл %s\n%s", recipient, out.offer.Name, prompt())
+	}
+	if err := sendChunks(conn, out, recipient, offset); err != nil {
+		fmt.Printf("\r❌ Ошибка отправки файла %s: %v\n%s", out.offer.Name, err, prompt())
+	}
+}
+
+// sendChunks отправляет до FileWindow частей начиная со смещения offset
+func sendChunks(conn *controlConn, out *outgoingFile, recipient string, offset int64) error {
+	f, err := os.Open(out.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, protocol.FileChunkSize)
+	for i := 0; i < protocol.FileWindow && offset < out.offer.Size; i++ {
+		n, err := f.ReadAt(buf, offset)
+		if n == 0 {
+			if err == nil || err == io.EOF {
+				err = errors.New("файл изменился после предложения")
+			}
+			return err
+		}
+		data, keyID, err := sealText(string(buf[:n]))
+		if err != nil {
+			return err
+		}
+		chunk := protocol.FileChunk{
+			ID:     out.offer.ID,
+			Peer:   recipient,
+			Offset: offset,
+			Data:   []byte(data),
+			Sum:    protocol.ChunkSum(buf[:n]),
+			KeyID:  keyID,
+		}
+		if err := sendControl(conn, chunk.Message()); err != nil {
+			return err
+		}
+		offset += int64(n)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"airchat/protocol"
+)
+
+const (
+	// defaultHeartbeat используется, если сервер не сообщил интервал
+	defaultHeartbeat = 10 * time.Second
+	// missedHeartbeats — сколько интервалов без ответа означает потерю сервера
+	missedHeartbeats = 3
+)
+
+var (
+	// lastHeard — время последнего сообщения от сервера, UnixNano
+	lastHeard atomic.Int64
+	// heartbeatInterval — интервал PING из WELCOME текущей сессии
+	heartbeatInterval atomic.Int64
+)
+
+// heartbeatLoop периодически отправляет PING, чтобы сервер не счёл
+// клиента отключившимся, и считает связь потерянной, если сервер
+// долго молчит
+func heartbeatLoop() {
+	for !exiting.Load() {
+		interval := time.Duration(heartbeatInterval.Load())
+		if interval <= 0 {
+			interval = defaultHeartbeat
+		}
+		time.Sleep(interval)
+
+		conn := activeConn()
+		if conn == nil {
+			continue
+		}
+		if err := sendFrame(conn, protocol.New(protocol.TypePing)); err != nil {
+			connLost(conn, err)
+			continue
+		}
+		silence := time.Since(time.Unix(0, lastHeard.Load()))
+		if silence > missedHeartbeats*interval {
+			connLost(conn, fmt.Errorf("сервер молчит %v", silence.Round(time.Second)))
+		}
+	}
+}
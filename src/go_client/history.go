@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"airchat/protocol"
+)
+
+// seenMessages — сообщения канала, которые уже показаны пользователю:
+// после переподключения сервер присылает историю повторно
+type seenMessages struct {
+	oldest uint64
+	ids    map[uint64]bool
+}
+
+var (
+	historyMu sync.Mutex
+	seen      = map[string]*seenMessages{}
+)
+
+// noteMessage отмечает сообщение канала как показанное и сообщает,
+// не было ли оно показано раньше
+func noteMessage(channel string, id uint64) bool {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	s, ok := seen[channel]
+	if !ok {
+		s = &seenMessages{oldest: id, ids: map[uint64]bool{}}
+		seen[channel] = s
+	}
+	if s.ids[id] {
+		return false
+	}
+	s.ids[id] = true
+	s.oldest = min(s.oldest, id)
+	return true
+}
+
+// oldestSeen возвращает самый ранний показанный идентификатор канала
+// или 0, если из канала ещё ничего не показано
+func oldestSeen(channel string) uint64 {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	if s, ok := seen[channel]; ok {
+		return s.oldest
+	}
+	return 0
+}
+
+// requestHistory запрашивает n сообщений текущего канала, предшествующих
+// самому раннему из показанных; пустое n — количество по умолчанию сервера
+func requestHistory(conn *controlConn, n string) error {
+	channel := currentChannel()
+	if channel == "" {
+		return errNoChannel
+	}
+	before := ""
+	if id := oldestSeen(channel); id != 0 {
+		before = protocol.FormatID(id)
+	}
+	return sendControl(conn, protocol.New(protocol.TypeHistory, channel, before, n))
+}
+
+// handleHistory выводит сообщение из истории канала со временем отправки
+func handleHistory(msg protocol.Message) {
+	e, err := protocol.ParseEntry(msg)
+	if err != nil || !noteMessage(e.Channel, e.ID) {
+		return
+	}
+	text := e.Text
+	if e.KeyID != "" {
+		var ok bool
+		if text, ok = openSealed(e.Sender, e.KeyID, []byte(text)); !ok {
+			return
+		}
+	}
+	if e.Edited {
+		text += " (изменено)"
+	}
+	id := protocol.FormatID(e.ID)
+	printInChannel(e.Channel, fmt.Sprintf("%s[%s] [%s]%s: %s",
+		messageLabel(id), formatTime(e.Time), e.Sender, replyLabel(e.Parent), text))
+	if len(e.Reactions) > 0 {
+		printReactions(e.Channel, id, e.Reactions, "")
+	}
+}
+
+// noteLive отмечает показанным живое сообщение канала с идентификатором
+// из поля сообщения; сообщения без идентификатора не учитываются
+func noteLive(channel, id string) {
+	if v, err := protocol.ParseID(id); err == nil {
+		noteMessage(channel, v)
+	}
+}
+
+// formatTime показывает время сообщения; для сообщений не за сегодня — и дату
+func formatTime(t time.Time) string {
+	t = t.Local()
+	now := time.Now()
+	if t.YearDay() == now.YearDay() && t.Year() == now.Year() {
+		return t.Format("15:04")
+	}
+	return t.Format("02.01.2006 15:04")
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Набираемая, но ещё не отправленная строка. Её показывает prompt(),
+// чтобы входящие сообщения не стирали набранный текст с экрана.
+var (
+	typedMu sync.Mutex
+	typed   []rune
+)
+
+// typedText возвращает набираемую строку
+func typedText() string {
+	typedMu.Lock()
+	defer typedMu.Unlock()
+	return string(typed)
+}
+
+// inputReader читает строки ввода. Если stdin — терминал, он переводится
+// в посимвольный режим, и строку редактирует сам клиент: так набор текста
+// виден до Enter (см. onEdit). Иначе ввод читается построчно, как раньше;
+// так работает и графический интерфейс, который пишет в stdin через канал,
+// — о наборе текста он сообщает строкой /typing.
+type inputReader struct {
+	scanner *bufio.Scanner // построчный режим
+	raw     *bufio.Reader  // посимвольный режим
+	restore func()         // возвращает терминал в исходный режим
+	line    string
+	lastCR  bool // предыдущий символ — '\r': следующий '\n' не новая строка
+
+	// onEdit вызывается после каждого изменения набираемой строки
+	onEdit func(line string)
+}
+
+func newInputReader(f *os.File) *inputReader {
+	if restore, err := makeRaw(int(f.Fd())); err == nil {
+		return &inputReader{raw: bufio.NewReader(f), restore: restore}
+	}
+	scanner := bufio.NewScanner(f)
+	// Строка может быть длиннее лимита сервера: такие сообщения
+	// отклоняются с понятной ошибкой, а не обрывают чтение ввода
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return &inputReader{scanner: scanner}
+}
+
+// Close возвращает терминал в исходный режим
+func (r *inputReader) Close() {
+	if r.restore != nil {
+		r.restore()
+	}
+}
+
+// Text возвращает строку, прочитанную последним Scan
+func (r *inputReader) Text() string {
+	return r.line
+}
+
+// Scan читает следующую строку; false — ввод закрыт (в терминале —
+// Ctrl+D на пустой строке или Ctrl+C)
+func (r *inputReader) Scan() bool {
+	if r.scanner != nil {
+		if !r.scanner.Scan() {
+			return false
+		}
+		r.line = r.scanner.Text()
+		return true
+	}
+
+	for {
+		c, _, err := r.raw.ReadRune()
+		if err != nil {
+			return false
+		}
+		lastCR := r.lastCR
+		r.lastCR = c == '\r'
+
+		typedMu.Lock()
+		switch {
+		case c == '\n' && lastCR:
+			typedMu.Unlock()
+			continue
+		case c == '\r' || c == '\n':
+			r.line = string(typed)
+			typed = typed[:0]
+			typedMu.Unlock()
+			fmt.Println()
+			r.edited()
+			return true
+		case c == 0x7f || c == '\b':
+			// Символ стирается вместе с комбинируемыми знаками после него
+			width := 0
+			for len(typed) > 0 {
+				last := typed[len(typed)-1]
+				typed = typed[:len(typed)-1]
+				width += runeWidth(last)
+				if width > 0 {
+					break
+				}
+			}
+			fmt.Print(strings.Repeat("\b \b", width))
+		case c == 0x15: // Ctrl+U стирает строку
+			fmt.Print(strings.Repeat("\b \b", textWidth(typed)))
+			typed = typed[:0]
+		case c == 0x03 || c == 0x04 && len(typed) == 0: // Ctrl+C, Ctrl+D
+			typedMu.Unlock()
+			fmt.Println()
+			return false
+		case c == 0x1b:
+			// Стрелки и другие управляющие последовательности не поддерживаются
+			typedMu.Unlock()
+			r.skipEscape()
+			continue
+		case c != utf8.RuneError && unicode.IsPrint(c):
+			typed = append(typed, c)
+			fmt.Print(string(c))
+		default:
+			typedMu.Unlock()
+			continue
+		}
+		typedMu.Unlock()
+		r.edited()
+	}
+}
+
+// edited сообщает об изменении набираемой строки
+func (r *inputReader) edited() {
+	if r.onEdit != nil {
+		r.onEdit(typedText())
+	}
+}
+
+// skipEscape пропускает последовательность вида ESC [ параметры буква
+func (r *inputReader) skipEscape() {
+	if c, _, err := r.raw.ReadRune(); err != nil || c != '[' && c != 'O' {
+		return
+	}
+	for {
+		c, _, err := r.raw.ReadRune()
+		if err != nil || c >= 0x40 && c <= 0x7e {
+			return
+		}
+	}
+}
+
+// wideRanges — символы, занимающие в терминале две колонки:
+// иероглифы, хангыль, полноширинные формы и эмодзи
+var wideRanges = []struct{ lo, hi rune }{
+	{0x1100, 0x115f},
+	{0x2e80, 0x303e},
+	{0x3041, 0x33ff},
+	{0x3400, 0x4dbf},
+	{0x4e00, 0x9fff},
+	{0xa000, 0xa4cf},
+	{0xac00, 0xd7a3},
+	{0xf900, 0xfaff},
+	{0xfe30, 0xfe4f},
+	{0xff00, 0xff60},
+	{0xffe0, 0xffe6},
+	{0x1f300, 0x1f64f},
+	{0x1f680, 0x1f6ff},
+	{0x1f900, 0x1f9ff},
+	{0x1fa70, 0x1faff},
+	{0x20000, 0x2fffd},
+	{0x30000, 0x3fffd},
+}
+
+// runeWidth — сколько колонок терминала занимает символ: комбинируемые
+// знаки и невидимые символы (ZWJ, селекторы вариантов) — ни одной
+func runeWidth(r rune) int {
+	if unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+	for _, w := range wideRanges {
+		if r < w.lo {
+			break
+		}
+		if r <= w.hi {
+			return 2
+		}
+	}
+	return 1
+}
+
+// textWidth — ширина строки в колонках терминала
+func textWidth(text []rune) int {
+	width := 0
+	for _, r := range text {
+		width += runeWidth(r)
+	}
+	return width
+}
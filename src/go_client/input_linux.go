@@ -0,0 +1,23 @@
+//go:build linux
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// makeRaw отключает построчный режим терминала, эхо и сигналы
+// (Ctrl+C обрабатывает inputReader); возвращает функцию восстановления.
+// Для не-терминала возвращает ошибку.
+func makeRaw(fd int) (func(), error) {
+	old, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	raw.Lflag &^= unix.ICANON | unix.ECHO | unix.ISIG
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, unix.TCSETS, old) }, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// makeRaw: посимвольный ввод реализован только для Linux, здесь ввод
+// остаётся построчным и TYPING отправляется только по строке /typing
+// (её присылает графический интерфейс)
+func makeRaw(fd int) (func(), error) {
+	return nil, errors.New("посимвольный ввод не поддерживается")
+}
@@ -2,14 +2,18 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"math"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"airchat/protocol"
 	"github.com/gordonklaus/portaudio"
 	"github.com/hraban/opus"
 )
@@ -262,6 +266,7 @@ func startAudioStream(conn *net.UDPConn, buffer *AudioBuffer) error {
 
 	// Буфер для закодированных данных
 	encodedData := make([]byte, maxBytes)
+	sealedData := make([]byte, 0, protocol.VoiceHeaderSize+maxBytes+16)
 
 	// Запускаем горутину для записи звука
 	audioWg.Add(1)
@@ -324,8 +329,14 @@ func startAudioStream(conn *net.UDPConn, buffer *AudioBuffer) error {
 						continue
 					}
 
-					// Отправляем закодированные данные
-					bytesWritten, err := conn.Write(encodedData[:n])
+					// Пока сервер не выдал ssrc и ключ, отправлять нечего
+					sealer := voiceSealer.Load()
+					if sealer == nil {
+						continue
+					}
+
+					// Шифруем и отправляем закодированные данные
+					bytesWritten, err := conn.Write(sealer.Seal(sealedData[:0], encodedData[:n]))
 					if err != nil {
 						fmt.Printf("Error sending audio data: %v\n", err)
 						continue
@@ -354,6 +365,7 @@ func startAudioStream(conn *net.UDPConn, buffer *AudioBuffer) error {
 		fmt.Println("Запущена горутина воспроизведения звука")
 
 		receiveBuf := make([]byte, maxBytes)
+		openedBuf := make([]byte, 0, maxBytes)
 		for {
 			select {
 			case <-stopAudio:
@@ -378,8 +390,15 @@ func startAudioStream(conn *net.UDPConn, buffer *AudioBuffer) error {
 				audioState.packetsReceived++
 				audioState.bytesReceived += int64(n)
 
+				// Проверяем подлинность и расшифровываем пакет
+				_, payload, err := voiceOpener.Open(openedBuf[:0], receiveBuf[:n])
+				if err != nil {
+					fmt.Printf("❌ Отброшен голосовой пакет: %v\n", err)
+					continue
+				}
+
 				// Декодируем полученные данные
-				samplesRead, err := buffer.Decoder.Decode(receiveBuf[:n], buffer.OpusOutputBuf)
+				samplesRead, err := buffer.Decoder.Decode(payload, buffer.OpusOutputBuf)
 				if err != nil {
 					fmt.Printf("❌ Ошибка декодирования: %v\n", err)
 					continue
@@ -517,7 +536,69 @@ func startAudioStream(conn *net.UDPConn, buffer *AudioBuffer) error {
 	return nil
 }
 
+// handshake выполняет обмен HELLO/WELCOME с сервером; resume — сессия,
+// которую нужно продолжить после переподключения.
+// UDP не гарантирует доставку, поэтому HELLO повторяется несколько раз.
+func handshake(conn *controlConn, resume string) (protocol.Welcome, error) {
+	hello := protocol.Hello{
+		Version:  protocol.Version,
+		Codecs:   []string{protocol.CodecOpus},
+		Features: []string{protocol.FeatureVoice, protocol.FeatureVoiceE2E, protocol.FeatureChatE2E, protocol.FeatureFiles},
+		Resume:   resume,
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	attempts, timeout := 3, 2*time.Second
+	if conn.stream {
+		attempts, timeout = 1, 5*time.Second
+	}
+
+	buffer := make([]byte, protocol.MaxFrame)
+	for attempt := 0; attempt < attempts; attempt++ {
+		if err := sendFrame(conn, hello.Message()); err != nil {
+			return protocol.Welcome{}, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+
+		for {
+			msg, err := conn.ReadMessage(buffer)
+			if err != nil {
+				var verr *protocol.VersionError
+				if errors.As(err, &verr) {
+					return protocol.Welcome{}, fmt.Errorf("несовместимая версия протокола: сервер %d, клиент %d",
+						verr.Got, protocol.Version)
+				}
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					break
+				}
+				if conn.stream {
+					return protocol.Welcome{}, err
+				}
+				continue
+			}
+
+			switch msg.Type {
+			case protocol.TypeWelcome:
+				welcome, err := protocol.ParseWelcome(msg)
+				if err != nil {
+					return protocol.Welcome{}, err
+				}
+				if welcome.Version != protocol.Version {
+					return protocol.Welcome{}, fmt.Errorf("несовместимая версия протокола: сервер %d, клиент %d",
+						welcome.Version, protocol.Version)
+				}
+				return welcome, nil
+			case protocol.TypeReject:
+				return protocol.Welcome{}, fmt.Errorf("сервер отклонил подключение: %s", msg.Field(0))
+			}
+		}
+	}
+	return protocol.Welcome{}, errors.New("сервер не отвечает")
+}
+
 func main() {
+	flag.Parse()
+
 	// Инициализируем PortAudio в начале программы
 	if err := initPortAudio(); err != nil {
 		fmt.Printf("Ошибка инициализации PortAudio: %v\n", err)
@@ -526,11 +607,18 @@ func main() {
 	// Гарантируем завершение работы PortAudio при выходе
 	defer terminatePortAudio()
 
+	var err error
+	identity, err = loadIdentity(*identityFile)
+	if err != nil {
+		fmt.Printf("Ошибка загрузки ключа идентичности: %v\n", err)
+		return
+	}
+
 	reader := bufio.NewReader(os.Stdin)
 
 	// Запрашиваем IP сервера
 	fmt.Print("Введите IP сервера (или нажмите Enter для localhost): ")
-	serverIP, _ := reader.ReadString('\n')
+	serverIP, _ = reader.ReadString('\n')
 	serverIP = strings.TrimSpace(serverIP)
 	if serverIP == "" {
 		serverIP = "127.0.0.1"
@@ -538,62 +626,102 @@ func main() {
 
 	// Запрашиваем имя пользователя
 	fmt.Print("Введите ваше имя: ")
-	username, _ := reader.ReadString('\n')
-	username = strings.TrimSpace(username)
-	for username == "" {
+	name, _ := reader.ReadString('\n')
+	name = strings.TrimSpace(name)
+	for name == "" {
 		fmt.Print("Имя не может быть пустым. Введите ваше имя: ")
-		username, _ = reader.ReadString('\n')
-		username = strings.TrimSpace(username)
+		name, _ = reader.ReadString('\n')
+		name = strings.TrimSpace(name)
 	}
+	setName(name)
 
-	serverAddr, err := net.ResolveUDPAddr("udp", serverIP+":6000")
-	if err != nil {
-		fmt.Println("Ошибка разрешения адреса:", err)
-		return
-	}
-
-	conn, err := net.DialUDP("udp", nil, serverAddr)
+	// Подключаемся и договариваемся с сервером о версии и возможностях
+	conn, welcome, err := connect("")
 	if err != nil {
 		fmt.Println("Ошибка подключения:", err)
 		return
 	}
-	defer conn.Close()
+	defer closeSession()
+	fmt.Printf("Сессия %s, возможности сервера: %v\n", welcome.Session, welcome.Features)
 
-	// Отправляем сообщение о подключении
-	_, err = conn.Write([]byte(username + " joined the chat"))
-	if err != nil {
+	// Входим в чат и запускаем чтение входящих сообщений
+	if err := startSession(conn, welcome); err != nil {
 		fmt.Println("Ошибка отправки:", err)
 		return
 	}
 
-	// Горутина для чтения входящих сообщений
-	go func() {
-		buffer := make([]byte, 4096)
-		for {
-			n, _, err := conn.ReadFromUDP(buffer)
-			if err != nil {
-				fmt.Println("Ошибка чтения:", err)
-				return
-			}
-			fmt.Printf("\r%s\n> ", string(buffer[:n]))
-		}
-	}()
+	// Горутина повторной отправки неподтверждённых сообщений
+	go retransmitLoop()
+	// Горутина проверки связи с сервером и переподключения
+	go heartbeatLoop()
+	// Горутина автоматического статуса away при простое
+	go awayLoop()
 
 	fmt.Println("\nДоступные команды:")
-	fmt.Println("/voice - подключиться к голосовому чату")
+	fmt.Println("/voice [канал] - подключиться к голосовому каналу или перейти в другой")
 	fmt.Println("/leave - отключиться от голосового чата")
+	fmt.Println("/voicelist - кто в каких голосовых каналах")
+	fmt.Println("/nick <имя> - сменить имя")
+	fmt.Println("/register <имя> <пароль> - зарегистрировать учётную запись")
+	fmt.Println("/login <имя> <пароль> - войти в учётную запись")
+	fmt.Println("/verify <имя> - показать отпечатки ключей для сверки")
+	fmt.Println("/join #канал - войти в канал и сделать его текущим")
+	fmt.Println("/part [#канал] - выйти из канала (по умолчанию из текущего)")
+	fmt.Println("/channels - список каналов и их участников")
+	fmt.Println("/msg <имя> <текст> - личное сообщение")
+	fmt.Println("/history [n] - показать n более ранних сообщений текущего канала")
+	fmt.Println("/search <запрос> [#канал] [from:имя] - поиск по истории ваших каналов")
+	fmt.Println("/edit <№> <текст> - исправить своё сообщение")
+	fmt.Println("/delete <№> - удалить своё сообщение")
+	fmt.Println("/react <№> <реакция> - поставить реакцию (👍, +1...) или снять её повтором")
+	fmt.Println("/reply <№> <текст> - ответить на сообщение")
+	fmt.Println("/thread <№> - показать обсуждение, в которое входит сообщение")
+	fmt.Println("/send <имя|#канал> <путь> - предложить файл")
+	fmt.Println("/accept <id> - принять предложенный файл (или продолжить прерванный приём)")
+	fmt.Println("/status [online|away|dnd] [текст] - показать или сменить статус")
 	fmt.Println("/exit - выйти из чата")
 	fmt.Println("Любой другой текст будет отправлен как сообщение")
 
 	// Чтение ввода пользователя
-	fmt.Print("> ")
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		text := scanner.Text()
+	input := newInputReader(os.Stdin)
+	defer input.Close()
+	input.onEdit = func(line string) {
+		noteActivity()
+		noteTyping(line)
+	}
+	fmt.Print(prompt())
+	for input.Scan() {
+		text := input.Text()
+		noteActivity()
+
+		command, arg := splitCommand(text)
+		if command == "/typing" {
+			// Сигнал набора от графического интерфейса: он сам решает, как
+			// часто его слать, поэтому интервал отсчитывается заново.
+			// Приглашение не выводится — это не команда пользователя.
+			typingSent = time.Time{}
+			sendTyping()
+			continue
+		}
+		conn := activeConn()
+		if conn == nil && command != "/verify" && command != "/exit" {
+			if command != "" {
+				fmt.Println("Нет связи с сервером, команда не выполнена")
+			} else if channel := currentChannel(); channel == "" {
+				fmt.Println(errNoChannel)
+			} else {
+				queueOffline(channel, text)
+				fmt.Println("⏳ Нет связи с сервером: сообщение будет отправлено после переподключения")
+			}
+			fmt.Print(prompt())
+			continue
+		}
 
-		switch text {
+		switch command {
 		case "/voice":
-			if voiceConn == nil {
+			if !welcome.Has(protocol.FeatureVoice) {
+				fmt.Println("Сервер не поддерживает голосовой чат")
+			} else if voiceConn == nil {
 				// Проверяем, что PortAudio инициализирован
 				if !paInitialized {
 					if err := initPortAudio(); err != nil {
@@ -635,11 +763,19 @@ func main() {
 					continue
 				}
 
-				// Отправляем уведомление о подключении к голосовому чату
-				conn.Write([]byte("VOICE_CONNECT"))
+				// Отправляем уведомление о подключении к голосовому каналу
+				// вместе с открытым ключом для согласования ключей голоса
+				if err := connectVoice(conn, channelArg(arg)); err != nil {
+					fmt.Printf("Ошибка создания ключей голоса: %v\n", err)
+					continue
+				}
+				inVoice.Store(true)
 				fmt.Println("Вы подключились к голосовому чату")
-			} else {
-				fmt.Println("Вы уже подключены к голосовому чату")
+			} else if channel := channelArg(arg); channel == "" || channel == currentVoiceChannel() {
+				fmt.Println("Вы уже подключены к голосовому каналу", currentVoiceChannel())
+			} else if err := connectVoice(conn, channel); err != nil {
+				// Аудио продолжает работать, меняется только канал
+				fmt.Printf("Ошибка создания ключей голоса: %v\n", err)
 			}
 
 		case "/leave":
@@ -649,7 +785,9 @@ func main() {
 				audioWg.Wait()
 
 				// Отправляем уведомление об отключении от голосового чата
-				conn.Write([]byte("VOICE_DISCONNECT"))
+				sendControl(conn, protocol.New(protocol.TypeVoiceDisconnect))
+				inVoice.Store(false)
+				leaveVoiceChannel()
 				voiceConn.Close()
 				voiceConn = nil
 				fmt.Println("Вы отключились от голосового чата")
@@ -657,25 +795,181 @@ func main() {
 				fmt.Println("Вы не подключены к голосовому чату")
 			}
 
+		case "/msg":
+			to, body, _ := strings.Cut(arg, " ")
+			if to == "" || strings.TrimSpace(body) == "" {
+				fmt.Println("Использование: /msg <имя> <текст>")
+			} else if err := sendDirect(conn, to, body); err != nil {
+				fmt.Println("Ошибка отправки:", err)
+			}
+
+		case "/history":
+			if _, err := strconv.Atoi(arg); arg != "" && err != nil {
+				fmt.Println("Использование: /history [n]")
+			} else if err := requestHistory(conn, arg); err != nil {
+				fmt.Println(err)
+			}
+
+		case "/search":
+			if err := sendSearch(conn, arg); err != nil {
+				fmt.Println(err)
+			}
+
+		case "/edit":
+			id, body, _ := strings.Cut(arg, " ")
+			if id == "" || strings.TrimSpace(body) == "" {
+				fmt.Println("Использование: /edit <№ сообщения> <новый текст>")
+			} else if err := sendEdit(conn, id, body); err != nil {
+				fmt.Println(err)
+			}
+
+		case "/delete":
+			if arg == "" {
+				fmt.Println("Использование: /delete <№ сообщения>")
+			} else if err := sendDelete(conn, arg); err != nil {
+				fmt.Println(err)
+			}
+
+		case "/react":
+			id, token, _ := strings.Cut(arg, " ")
+			if id == "" || strings.TrimSpace(token) == "" {
+				fmt.Println("Использование: /react <№ сообщения> <реакция>")
+			} else if err := sendReaction(conn, id, strings.TrimSpace(token)); err != nil {
+				fmt.Println(err)
+			}
+
+		case "/reply":
+			id, body, _ := strings.Cut(arg, " ")
+			if id == "" || strings.TrimSpace(body) == "" {
+				fmt.Println("Использование: /reply <№ сообщения> <текст>")
+			} else if err := sendReply(conn, id, body); err != nil {
+				fmt.Println(err)
+			}
+
+		case "/thread":
+			if arg == "" {
+				fmt.Println("Использование: /thread <№ сообщения>")
+			} else if err := requestThread(conn, arg); err != nil {
+				fmt.Println(err)
+			}
+
+		case "/send":
+			to, path, _ := strings.Cut(arg, " ")
+			if to == "" || strings.TrimSpace(path) == "" {
+				fmt.Println("Использование: /send <имя|#канал> <путь>")
+			} else if !welcome.Has(protocol.FeatureFiles) {
+				fmt.Println("Сервер не принимает файлы")
+			} else if err := sendFile(conn, to, strings.TrimSpace(path)); err != nil {
+				fmt.Println("Ошибка отправки файла:", err)
+			}
+
+		case "/accept":
+			if arg == "" {
+				fmt.Println("Использование: /accept <id>")
+			} else if err := acceptFile(arg); err != nil {
+				fmt.Println(err)
+			}
+
+		case "/status":
+			if err := setStatus(conn, arg); err != nil {
+				fmt.Println(err)
+			}
+
+		case "/voicelist":
+			sendControl(conn, protocol.New(protocol.TypeVoiceList))
+
+		case "/nick":
+			if arg == "" {
+				fmt.Println("Использование: /nick <имя>")
+			} else if arg != currentName() {
+				sendControl(conn, protocol.New(protocol.TypeNick, arg))
+			}
+
+		case "/register":
+			authCommand(conn, protocol.TypeRegister, command, arg)
+
+		case "/login":
+			authCommand(conn, protocol.TypeLogin, command, arg)
+
+		case "/verify":
+			verifyIdentity(arg)
+
+		case "/join":
+			if arg == "" {
+				fmt.Println("Использование: /join #канал")
+			} else {
+				sendControl(conn, protocol.New(protocol.TypeChannelJoin, channelArg(arg)))
+			}
+
+		case "/part":
+			channel := channelArg(arg)
+			if channel == "" {
+				channel = currentChannel()
+			}
+			if channel == "" {
+				fmt.Println("Вы не в канале")
+			} else {
+				sendControl(conn, protocol.New(protocol.TypeChannelPart, channel))
+			}
+
+		case "/channels":
+			sendControl(conn, protocol.New(protocol.TypeChannels))
+
 		case "/exit":
 			if voiceConn != nil {
 				// Останавливаем аудио потоки
 				close(stopAudio)
 				audioWg.Wait()
 
-				conn.Write([]byte("VOICE_DISCONNECT"))
+				if conn != nil {
+					sendControl(conn, protocol.New(protocol.TypeVoiceDisconnect))
+				}
 				voiceConn.Close()
 			}
+			leaveChat(conn)
 			return
 
 		default:
-			// Отправляем обычное сообщение
-			_, err := conn.Write([]byte("[" + username + "]: " + text))
-			if err != nil {
-				fmt.Println("Ошибка отправки:", err)
-				return
+			// Отправляем обычное сообщение в текущий канал
+			channel := currentChannel()
+			err := sendChat(conn, channel, text)
+			var tooLarge *protocol.MessageTooLargeError
+			if errors.As(err, &tooLarge) {
+				fmt.Printf("Сообщение слишком длинное: %d байт при лимите %d\n", tooLarge.Size, tooLarge.Limit)
+			} else if errors.Is(err, errNoChannel) {
+				fmt.Println(err)
+			} else if err != nil {
+				// Сообщение уйдёт после переподключения
+				connLost(conn, err)
+				queueOffline(channel, text)
 			}
 		}
-		fmt.Print("> ")
+		fmt.Print(prompt())
+	}
+
+	// Ввод закрыт — выходим так же, как по /exit
+	leaveChat(activeConn())
+}
+
+// leaveChat сообщает серверу о выходе из чата, чтобы остальные
+// увидели уход, а имя освободилось сразу, не дожидаясь таймаута
+func leaveChat(conn *controlConn) {
+	exiting.Store(true)
+	if conn == nil {
+		return
+	}
+	if err := sendControl(conn, protocol.New(protocol.TypeLeave)); err != nil {
+		return
+	}
+	waitAcked(conn, time.Second)
+}
+
+// splitCommand отделяет команду вида "/cmd" от её аргумента;
+// для обычного текста команда пуста
+func splitCommand(text string) (command, arg string) {
+	if !strings.HasPrefix(text, "/") {
+		return "", text
 	}
+	command, arg, _ = strings.Cut(text, " ")
+	return command, strings.TrimSpace(arg)
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"airchat/protocol"
+)
+
+// sendReaction ставит реакцию на сообщение или снимает уже поставленную
+func sendReaction(conn *controlConn, id, token string) error {
+	id, err := messageID(id)
+	if err != nil {
+		return err
+	}
+	return sendControl(conn, protocol.New(protocol.TypeReact, id, token))
+}
+
+// formatReactions показывает реакции с количеством: 👍 2  🎉 1
+func formatReactions(counts map[string]int) string {
+	tokens := make([]string, 0, len(counts))
+	for t := range counts {
+		tokens = append(tokens, t)
+	}
+	sort.Strings(tokens)
+	for i, t := range tokens {
+		tokens[i] = fmt.Sprintf("%s %d", t, counts[t])
+	}
+	return strings.Join(tokens, "  ")
+}
+
+// printReactions выводит строку реакций под сообщением канала
+func printReactions(channel, id string, counts map[string]int, note string) {
+	line := "   └ " + messageLabel(id)
+	if len(counts) == 0 {
+		line += "реакций нет"
+	} else {
+		line += formatReactions(counts)
+	}
+	if note != "" {
+		line += "  — " + note
+	}
+	printInChannel(channel, line)
+}
+
+// handleReact выводит изменившиеся реакции
+// [канал, id, кто, реакция, +1 или -1, реакции]
+func handleReact(msg protocol.Message) {
+	channel, id, who, token := msg.Field(0), msg.Field(1), msg.Field(2), msg.Field(3)
+	note := who + " поставил " + token
+	if msg.Field(4) == "-1" {
+		note = who + " убрал " + token
+	}
+	printReactions(channel, id, protocol.ParseReactions(msg.Field(5)), note)
+}
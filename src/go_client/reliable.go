@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"airchat/protocol"
+)
+
+// setMessageLimit применяет лимит размера сообщения, объявленный сервером
+func (c *controlConn) setMessageLimit(limit int) {
+	if limit <= 0 || limit > protocol.LimitMaxMessage {
+		limit = protocol.DefaultMaxMessage
+	}
+	c.fragmenter = protocol.NewFragmenter(limit)
+	c.reassembler = protocol.NewReassembler(limit)
+}
+
+// sendFrame отправляет сообщение серверу без гарантии доставки
+func sendFrame(conn *controlConn, msg protocol.Message) error {
+	data, err := protocol.Encode(msg)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+	return err
+}
+
+// sendControl отправляет нумерованное сообщение, при необходимости
+// разрезав его на фрагменты; каждая часть повторяется, пока сервер
+// её не подтвердит
+func sendControl(conn *controlConn, msg protocol.Message) error {
+	if conn.stream {
+		data, err := conn.fragmenter.Encode(msg)
+		if err != nil {
+			return err
+		}
+		_, err = conn.Write(data)
+		return err
+	}
+
+	parts, err := conn.fragmenter.Split(msg)
+	if err != nil {
+		return err
+	}
+	for _, part := range parts {
+		data, err := conn.out.Prepare(part)
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receive подтверждает нумерованные сообщения, отбрасывает дубликаты
+// и передаёт остальные на обработку по порядку
+func receive(conn *controlConn, msg protocol.Message) {
+	lastHeard.Store(time.Now().UnixNano())
+	if msg.Type == protocol.TypeAck {
+		conn.out.Ack(msg.Seq)
+		return
+	}
+	if !msg.Reliable() {
+		handleServerMessage(conn, msg)
+		return
+	}
+
+	sendFrame(conn, protocol.AckFor(msg))
+	for _, m := range conn.in.Accept(msg) {
+		handleServerMessage(conn, m)
+	}
+}
+
+func handleServerMessage(conn *controlConn, msg protocol.Message) {
+	switch msg.Type {
+	case protocol.TypePong:
+		// достаточно того, что receive обновил lastHeard
+	case protocol.TypeChat:
+		noteLive(msg.Field(2), msg.Field(3))
+		printChat(msg.Field(0), msg.Field(1), msg.Field(2), msg.Field(3), protocol.ParseExcerpt(msg.Fields[min(5, len(msg.Fields)):]))
+	case protocol.TypeNotice:
+		fmt.Printf("\r%s\n%s", msg.Field(0), prompt())
+	case protocol.TypeReject:
+		fmt.Printf("\rСервер отклонил запрос: %s\n%s", msg.Field(0), prompt())
+	case protocol.TypeResync:
+		// Сообщения после потерянного уже подтверждены: сервер их не повторит
+		for _, m := range conn.in.Drain() {
+			handleServerMessage(conn, m)
+		}
+		connLost(conn, errors.New("сервер не смог доставить сообщение"))
+	case protocol.TypeVoiceAccept:
+		handleVoiceAccept(msg)
+	case protocol.TypeVoicePeer:
+		handleVoicePeer(conn, msg)
+	case protocol.TypeVoiceKey:
+		handleVoiceKey(msg)
+	case protocol.TypeVoicePeerLeft:
+		handleVoicePeerLeft(msg)
+	case protocol.TypeIdentity:
+		handleIdentity(conn, msg)
+	case protocol.TypeGroupKey:
+		handleGroupKey(msg)
+	case protocol.TypeSealedChat:
+		handleSealedChat(msg)
+	case protocol.TypeDirect:
+		handleDirect(msg)
+	case protocol.TypeHistory:
+		handleHistory(msg)
+	case protocol.TypeSearch:
+		handleSearch(msg)
+	case protocol.TypeEdit:
+		handleEdit(msg)
+	case protocol.TypeDelete:
+		handleDelete(msg)
+	case protocol.TypeReact:
+		handleReact(msg)
+	case protocol.TypeThread:
+		handleThread(msg)
+	case protocol.TypeFileOffer:
+		handleFileOffer(msg)
+	case protocol.TypeFileAccept:
+		handleFileAccept(conn, msg)
+	case protocol.TypeFileChunk:
+		handleFileChunk(msg)
+	case protocol.TypeTyping:
+		handleTyping(msg)
+	case protocol.TypeStatus:
+		handleStatus(msg)
+	case protocol.TypeAuthOK:
+		handleAuthOK(msg)
+	case protocol.TypeRename:
+		handleRename(msg)
+	case protocol.TypeChannelJoin:
+		handleChannelJoin(msg)
+	case protocol.TypeChannelPart:
+		handleChannelPart(msg)
+	case protocol.TypeChannels:
+		handleChannels(msg)
+	case protocol.TypeVoiceList:
+		handleVoiceList(msg)
+	case protocol.TypeFragment:
+		inner, complete, err := conn.reassembler.Add(msg)
+		if err != nil {
+			fmt.Printf("\r❌ Не удалось собрать сообщение: %v\n%s", err, prompt())
+			return
+		}
+		if complete {
+			handleServerMessage(conn, inner)
+		}
+	}
+}
+
+// printChat выводит сообщение с отправителем, которого указал сервер,
+// его идентификатором для /edit и /delete и началом сообщения,
+// на которое оно отвечает
+func printChat(sender, text, channel, id string, parent protocol.Excerpt) {
+	stopTyping(channel, sender)
+	printInChannel(channel, fmt.Sprintf("%s[%s]%s: %s", messageLabel(id), sender, replyLabel(parent), text))
+}
+
+// messageLabel — метка идентификатора перед текстом сообщения
+func messageLabel(id string) string {
+	if id == "" {
+		return ""
+	}
+	return "№" + id + " "
+}
+
+// printInChannel выводит строку канала; строки не из текущего канала
+// помечаются именем канала
+func printInChannel(channel, line string) {
+	if channel != "" && channel != currentChannel() {
+		line = channel + " " + line
+	}
+	fmt.Printf("\r%s\n%s", line, prompt())
+}
+
+// waitAcked ждёт, пока сервер подтвердит все отправленные сообщения,
+// но не дольше timeout
+func waitAcked(conn *controlConn, timeout time.Duration) {
+	if conn == nil || conn.stream {
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	for conn.out.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// retransmitLoop повторяет неподтверждённые сообщения текущего
+// UDP-соединения; потоковым соединениям это не нужно. После потери
+// сообщения сессия продолжается заново, с новой нумерацией.
+func retransmitLoop() {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		conn := activeConn()
+		if conn == nil || conn.stream {
+			continue
+		}
+		resend, lost := conn.out.Due(now)
+		for _, data := range resend {
+			conn.Write(data)
+		}
+		for _, msg := range lost {
+			if msg.Type == protocol.TypeChat {
+				fmt.Printf("\r❌ Сообщение не доставлено: %s\n%s", msg.Field(0), prompt())
+			} else {
+				fmt.Printf("\r❌ Сервер не подтвердил %s\n%s", msg.Type, prompt())
+			}
+		}
+		if len(lost) > 0 {
+			// Сервер ждёт потерянный номер и не обрабатывает следующие:
+			// нумерация начнётся заново в продолженной сессии
+			connLost(conn, errors.New("сообщение не подтверждено"))
+		}
+	}
+}
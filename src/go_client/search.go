@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"airchat/protocol"
+)
+
+var errEmptyQuery = errors.New("Использование: /search <запрос> [#канал] [from:имя]")
+
+// lastSearchHit — найденное сообщение, к которому относилась последняя
+// выведенная строка результата. Сбрасывается горутиной ввода при новом
+// поиске, читается горутиной приёма, поэтому защищено searchMu.
+var (
+	searchMu      sync.Mutex
+	lastSearchHit uint64
+)
+
+// sendSearch разбирает аргументы /search: слова с '#' задают канал,
+// from:имя — отправителя, остальное — запрос
+func sendSearch(conn *controlConn, arg string) error {
+	var words []string
+	var channel, from string
+	for _, w := range strings.Fields(arg) {
+		switch {
+		case strings.HasPrefix(w, "#"):
+			channel = channelArg(w)
+		case strings.HasPrefix(w, "from:"):
+			from = strings.TrimPrefix(w, "from:")
+		default:
+			words = append(words, w)
+		}
+	}
+	if len(words) == 0 {
+		return errEmptyQuery
+	}
+	searchMu.Lock()
+	lastSearchHit = 0
+	searchMu.Unlock()
+	return sendControl(conn, protocol.New(protocol.TypeSearch, strings.Join(words, " "), channel, from))
+}
+
+// handleSearch выводит строку результата поиска. Строки одного
+// найденного сообщения идут подряд: сначала контекст до него,
+// затем оно само (отмечено »), затем контекст после
+func handleSearch(msg protocol.Message) {
+	hit, e, err := protocol.ParseSearchResult(msg)
+	if err != nil {
+		return
+	}
+	var out strings.Builder
+	searchMu.Lock()
+	if hit != lastSearchHit {
+		lastSearchHit = hit
+		fmt.Fprintf(&out, "🔎 %s, сообщение №%d:\n", e.Channel, hit)
+	}
+	searchMu.Unlock()
+	mark := " "
+	if e.ID == hit {
+		mark = "»"
+	}
+	fmt.Fprintf(&out, " %s №%d [%s] [%s]: %s", mark, e.ID, formatTime(e.Time), e.Sender, e.Text)
+	fmt.Printf("\r%s\n%s", out.String(), prompt())
+}
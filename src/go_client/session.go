@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"airchat/protocol"
+)
+
+const (
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = 30 * time.Second
+)
+
+var (
+	serverIP string // адрес сервера, введённый при запуске
+
+	sessionMu sync.Mutex
+	active    *controlConn // текущее соединение, nil — связи нет
+	sessionID string       // сессия из последнего WELCOME, для возобновления
+	offline   []outgoing   // сообщения, набранные без связи
+	exiting   atomic.Bool  // клиент завершает работу, переподключаться не нужно
+	inVoice   atomic.Bool  // пользователь в голосовом чате (нужно вернуться после переподключения)
+)
+
+// outgoing — сообщение, ожидающее отправки в канал
+type outgoing struct {
+	channel, text string
+}
+
+// activeConn возвращает текущее соединение с сервером или nil, если связи нет
+func activeConn() *controlConn {
+	sessionMu.Lock()
+	defer sessionMu.Unlock()
+	return active
+}
+
+// connect подключается к серверу и выполняет рукопожатие; непустой
+// resume просит сервер продолжить прежнюю сессию
+func connect(resume string) (*controlConn, protocol.Welcome, error) {
+	conn, err := dialControl(serverIP)
+	if err != nil {
+		return nil, protocol.Welcome{}, err
+	}
+	welcome, err := handshake(conn, resume)
+	if err != nil {
+		conn.Close()
+		return nil, protocol.Welcome{}, err
+	}
+	conn.setMessageLimit(welcome.MaxMessage)
+	return conn, welcome, nil
+}
+
+// startSession делает conn текущим соединением. Если сервер не продолжил
+// прежнюю сессию, клиент входит в чат заново: повторяет вход в учётную
+// запись и JOIN. Затем возвращается в голосовой чат и отправляет
+// сообщения, набранные без связи.
+func startSession(conn *controlConn, welcome protocol.Welcome) error {
+	heartbeatInterval.Store(int64(welcome.Heartbeat))
+	if !welcome.Resumed {
+		if err := configureChatE2E(welcome.Has(protocol.FeatureChatE2E)); err != nil {
+			return err
+		}
+		if name, password, ok := savedCredentials(); ok {
+			// Вход до JOIN: зарегистрированное имя иначе будет отклонено
+			if err := sendControl(conn, protocol.New(protocol.TypeLogin, name, password)); err != nil {
+				return err
+			}
+		}
+		// Отправляем сообщение о подключении вместе с ключом идентичности
+		err := sendControl(conn, protocol.New(protocol.TypeJoin, currentName(), string(identity.PublicKey().Bytes())))
+		if err != nil {
+			return err
+		}
+		if err := rejoinChannels(conn); err != nil {
+			return err
+		}
+		if err := restoreStatus(conn); err != nil {
+			return err
+		}
+	}
+
+	sessionMu.Lock()
+	active = conn
+	sessionID = welcome.Session
+	queued := offline
+	offline = nil
+	sessionMu.Unlock()
+	lastHeard.Store(time.Now().UnixNano())
+
+	go readLoop(conn)
+
+	if inVoice.Load() {
+		rejoinVoice(conn)
+	}
+	for _, m := range queued {
+		if err := sendChat(conn, m.channel, m.text); err != nil {
+			fmt.Printf("\r❌ Сообщение не отправлено: %v\n%s", err, prompt())
+		}
+	}
+	return nil
+}
+
+// readLoop читает сообщения соединения, пока оно не оборвётся
+func readLoop(conn *controlConn) {
+	buffer := make([]byte, protocol.MaxFrame)
+	for {
+		msg, err := conn.ReadMessage(buffer)
+		if err != nil {
+			// Ошибка сокета или потока фатальна, битая датаграмма — нет
+			var netErr net.Error
+			if conn.stream || errors.As(err, &netErr) {
+				connLost(conn, err)
+				return
+			}
+			fmt.Printf("\rНекорректное сообщение от сервера: %v\n%s", err, prompt())
+			continue
+		}
+		receive(conn, msg)
+	}
+}
+
+// connLost закрывает оборвавшееся соединение и запускает переподключение.
+// Повторные вызовы для того же соединения ничего не делают.
+func connLost(conn *controlConn, reason error) {
+	sessionMu.Lock()
+	if active != conn {
+		sessionMu.Unlock()
+		return
+	}
+	active = nil
+	sessionMu.Unlock()
+
+	conn.Close()
+	if exiting.Load() {
+		return
+	}
+	fmt.Printf("\r⚠️ Связь с сервером потеряна (%v), переподключение...\n%s", reason, prompt())
+	go reconnectLoop()
+}
+
+// reconnectLoop переподключается с экспоненциальной задержкой,
+// пытаясь продолжить прежнюю сессию
+func reconnectLoop() {
+	delay := reconnectMinDelay
+	for !exiting.Load() {
+		// Случайная добавка, чтобы клиенты не переподключались все разом
+		time.Sleep(delay + time.Duration(rand.Int63n(int64(delay/2))))
+
+		sessionMu.Lock()
+		resume := sessionID
+		sessionMu.Unlock()
+
+		conn, welcome, err := connect(resume)
+		if err == nil {
+			if err = startSession(conn, welcome); err == nil {
+				if welcome.Resumed {
+					fmt.Printf("\r✅ Переподключено, сессия восстановлена\n%s", prompt())
+				} else {
+					fmt.Printf("\r✅ Переподключено, начата новая сессия %s\n%s", welcome.Session, prompt())
+				}
+				return
+			}
+			conn.Close()
+		}
+
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+		fmt.Printf("\r❌ Не удалось переподключиться: %v, следующая попытка через %v\n%s", err, delay, prompt())
+	}
+}
+
+// queueOffline откладывает сообщение в канал до восстановления связи
+func queueOffline(channel, text string) {
+	sessionMu.Lock()
+	offline = append(offline, outgoing{channel, text})
+	sessionMu.Unlock()
+}
+
+// closeSession завершает работу с сервером без переподключения
+func closeSession() {
+	exiting.Store(true)
+	if conn := activeConn(); conn != nil {
+		conn.Close()
+	}
+}
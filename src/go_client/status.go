@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"airchat/protocol"
+)
+
+var awayAfter = flag.Duration("away-after", 10*time.Minute,
+	"через сколько без ввода ставить статус away (0 — не ставить)")
+
+// presence — статус присутствия пользователя
+type presence struct {
+	state string
+	text  string
+}
+
+// describe показывает статус словами: «нет на месте — обедаю»
+func (p presence) describe() string {
+	var s string
+	switch p.state {
+	case protocol.StatusAway:
+		s = "нет на месте"
+	case protocol.StatusDND:
+		s = "не беспокоить"
+	default:
+		s = "в сети"
+	}
+	if p.text != "" {
+		s += " — " + p.text
+	}
+	return s
+}
+
+// icon — значок статуса в списках и уведомлениях
+func (p presence) icon() string {
+	switch p.state {
+	case protocol.StatusAway:
+		return "🌙"
+	case protocol.StatusDND:
+		return "⛔"
+	}
+	return "🟢"
+}
+
+var (
+	statusMu sync.Mutex
+	// ownStatus — статус, выбранный пользователем; autoAway — сейчас
+	// вместо него показывается away из-за простоя
+	ownStatus = presence{state: protocol.StatusOnline}
+	autoAway  bool
+	statuses  = map[string]presence{} // статусы остальных по именам
+
+	lastInput atomic.Int64 // время последнего ввода пользователя, UnixNano
+)
+
+// sendStatus отправляет серверу статус присутствия
+func sendStatus(conn *controlConn, p presence) error {
+	return sendControl(conn, protocol.New(protocol.TypeStatus, p.state, p.text))
+}
+
+// setStatus обрабатывает /status [online|away|dnd] [текст];
+// без аргументов показывает текущий статус
+func setStatus(conn *controlConn, arg string) error {
+	if arg == "" {
+		statusMu.Lock()
+		p := ownStatus
+		statusMu.Unlock()
+		fmt.Printf("Ваш статус: %s %s\n", p.icon(), p.describe())
+		return nil
+	}
+	state, text, _ := strings.Cut(arg, " ")
+	p := presence{state: strings.ToLower(state), text: strings.TrimSpace(text)}
+	if !protocol.ValidStatus(p.state, p.text) {
+		return errors.New("некорректный статус, использование: /status online|away|dnd [текст]")
+	}
+
+	statusMu.Lock()
+	ownStatus, autoAway = p, false
+	statusMu.Unlock()
+	return sendStatus(conn, p)
+}
+
+// restoreStatus повторяет свой статус в новой сессии: сервер
+// считает нового участника просто находящимся в сети
+func restoreStatus(conn *controlConn) error {
+	statusMu.Lock()
+	p := ownStatus
+	if autoAway {
+		p = presence{state: protocol.StatusAway, text: ownStatus.text}
+	}
+	statusMu.Unlock()
+	if p == (presence{state: protocol.StatusOnline}) {
+		return nil
+	}
+	return sendStatus(conn, p)
+}
+
+// noteActivity отмечает ввод пользователя; если статус away был
+// поставлен из-за простоя, возвращает прежний
+func noteActivity() {
+	lastInput.Store(time.Now().UnixNano())
+
+	statusMu.Lock()
+	back := autoAway
+	autoAway = false
+	p := ownStatus
+	statusMu.Unlock()
+
+	if conn := activeConn(); back && conn != nil {
+		sendStatus(conn, p)
+	}
+}
+
+// awayLoop ставит статус away, если пользователь дольше -away-after
+// ничего не вводил. Выбранные вручную away и dnd не меняются.
+func awayLoop() {
+	if *awayAfter <= 0 {
+		return
+	}
+	lastInput.Store(time.Now().UnixNano())
+	ticker := time.NewTicker(min(*awayAfter/2, 10*time.Second))
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if time.Since(time.Unix(0, lastInput.Load())) < *awayAfter {
+			continue
+		}
+		statusMu.Lock()
+		idle := !autoAway && ownStatus.state == protocol.StatusOnline
+		if idle {
+			autoAway = true
+		}
+		text := ownStatus.text
+		statusMu.Unlock()
+
+		if conn := activeConn(); idle && conn != nil {
+			sendStatus(conn, presence{state: protocol.StatusAway, text: text})
+		}
+	}
+}
+
+// handleStatus сообщает о смене статуса [кто, состояние, текст].
+// Сервер присылает «в сети» о каждом новом участнике — об этом не пишем.
+func handleStatus(msg protocol.Message) {
+	name := msg.Field(0)
+	p := presence{state: msg.Field(1), text: msg.Field(2)}
+
+	statusMu.Lock()
+	previous, known := statuses[name]
+	statuses[name] = p
+	statusMu.Unlock()
+
+	switch {
+	case name == currentName():
+		fmt.Printf("\rВаш статус: %s %s\n%s", p.icon(), p.describe(), prompt())
+	case p == previous || !known && p == (presence{state: protocol.StatusOnline}):
+	default:
+		fmt.Printf("\r%s %s: %s\n%s", p.icon(), name, p.describe(), prompt())
+	}
+}
+
+// renamePresence переносит статус пользователя на новое имя
+func renamePresence(old, name string) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	if p, ok := statuses[old]; ok {
+		delete(statuses, old)
+		statuses[name] = p
+	}
+}
+
+// presenceMark — значок рядом с именем в списках; для тех, кто просто
+// в сети, пусто
+func presenceMark(name string) string {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	if p, ok := statuses[name]; ok && p.state != protocol.StatusOnline {
+		return " " + p.icon()
+	}
+	return ""
+}
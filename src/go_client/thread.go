@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"airchat/protocol"
+)
+
+// Обсуждение, которое сейчас выводится. Сбрасывается горутиной ввода
+// при новом запросе, используется горутиной приёма, поэтому защищено threadMu.
+var (
+	threadMu    sync.Mutex
+	threadRoot  uint64
+	threadDepth = map[uint64]int{}
+)
+
+// replyLabel показывает начало сообщения, на которое отвечают:
+// « ↪ №5 alice «текст»»; для сообщений, которые не ответ, — пусто
+func replyLabel(parent protocol.Excerpt) string {
+	if parent.ID == 0 {
+		return ""
+	}
+	label := " ↪ " + messageLabel(protocol.FormatID(parent.ID))
+	if parent.Sender == "" {
+		return strings.TrimSuffix(label, " ")
+	}
+	return label + parent.Sender + " «" + excerptText(parent) + "»"
+}
+
+// excerptText возвращает текст родительского сообщения, при необходимости
+// расшифровав его. Ошибки не выводятся: ответ показывается и без цитаты.
+func excerptText(parent protocol.Excerpt) string {
+	if parent.Text == "" {
+		return "удалено"
+	}
+	if parent.KeyID == "" {
+		return parent.Text
+	}
+	text, _, err := decryptSealed(parent.KeyID, []byte(parent.Text))
+	if err != nil {
+		return "🔒"
+	}
+	return protocol.Shorten(text, protocol.ExcerptLength)
+}
+
+// requestThread запрашивает обсуждение, в которое входит сообщение
+func requestThread(conn *controlConn, id string) error {
+	id, err := messageID(id)
+	if err != nil {
+		return err
+	}
+	threadMu.Lock()
+	threadRoot = 0
+	threadMu.Unlock()
+	return sendControl(conn, protocol.New(protocol.TypeThread, id))
+}
+
+// handleThread выводит сообщение обсуждения; ответы сдвигаются
+// вправо по глубине вложенности
+func handleThread(msg protocol.Message) {
+	root, e, err := protocol.ParseThreadEntry(msg)
+	if err != nil {
+		return
+	}
+	threadMu.Lock()
+	defer threadMu.Unlock()
+	if root != threadRoot {
+		threadRoot = root
+		clear(threadDepth)
+		fmt.Printf("\r🧵 Обсуждение %s%s:\n%s", messageLabel(protocol.FormatID(root)), e.Channel, prompt())
+	}
+	text := e.Text
+	if e.KeyID != "" {
+		var ok bool
+		if text, ok = openSealed(e.Sender, e.KeyID, []byte(text)); !ok {
+			return
+		}
+	}
+
+	depth := 0
+	if e.Parent.ID != 0 {
+		// Ответ на удалённое сообщение показывается на первом уровне
+		depth = threadDepth[e.Parent.ID] + 1
+	}
+	threadDepth[e.ID] = depth
+	fmt.Printf("\r%s%s[%s] [%s]: %s\n%s", strings.Repeat("    ", depth),
+		messageLabel(protocol.FormatID(e.ID)), formatTime(e.Time), e.Sender, text, prompt())
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"airchat/protocol"
+)
+
+var (
+	useTLS  = flag.Bool("tls", false, "использовать TLS поверх TCP для чата и сигнализации (голос остаётся на UDP)")
+	tlsPort = flag.Int("tls-port", 6443, "порт TLS-листенера сервера")
+	tlsCA   = flag.String("tls-ca", "", "PEM-файл с сертификатом сервера или CA для проверки")
+	tlsPin  = flag.String("tls-pin", "", "SHA-256 отпечаток сертификата сервера (для самоподписанных)")
+)
+
+// controlConn — управляющий канал клиента: UDP с собственной надёжностью
+// или TLS поверх TCP
+type controlConn struct {
+	net.Conn
+	stream bool // доставку, порядок и границы кадров обеспечивает транспорт
+	r      *bufio.Reader
+
+	out *protocol.Sender   // наши нумерованные сообщения серверу
+	in  *protocol.Receiver // нумерованные сообщения от сервера
+
+	// Лимит на размер сообщения уточняется после WELCOME (см. setMessageLimit)
+	fragmenter  *protocol.Fragmenter
+	reassembler *protocol.Reassembler
+}
+
+func newControlConn(conn net.Conn, stream bool) *controlConn {
+	c := &controlConn{
+		Conn:        conn,
+		stream:      stream,
+		out:         protocol.NewSender(),
+		in:          protocol.NewReceiver(),
+		fragmenter:  protocol.NewFragmenter(protocol.DefaultMaxMessage),
+		reassembler: protocol.NewReassembler(protocol.DefaultMaxMessage),
+	}
+	if stream {
+		c.r = bufio.NewReader(conn)
+	}
+	return c
+}
+
+// dialControl подключается к серверу выбранным транспортом
+func dialControl(serverIP string) (*controlConn, error) {
+	if !*useTLS {
+		serverAddr, err := net.ResolveUDPAddr("udp", serverIP+":6000")
+		if err != nil {
+			return nil, fmt.Errorf("ошибка разрешения адреса: %v", err)
+		}
+		conn, err := net.DialUDP("udp", nil, serverAddr)
+		if err != nil {
+			return nil, err
+		}
+		return newControlConn(conn, false), nil
+	}
+
+	config, err := tlsConfig(serverIP)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := tls.Dial("tcp", net.JoinHostPort(serverIP, strconv.Itoa(*tlsPort)), config)
+	if err != nil {
+		return nil, err
+	}
+	if *tlsCA == "" && *tlsPin == "" {
+		state := conn.ConnectionState()
+		sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+		fmt.Printf("⚠️ Сертификат сервера не проверен. Отпечаток: %s\n", hex.EncodeToString(sum[:]))
+		fmt.Println("   Сверьте его с журналом сервера и используйте -tls-pin")
+	}
+	return newControlConn(conn, true), nil
+}
+
+func tlsConfig(serverIP string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverIP}
+
+	switch {
+	case *tlsCA != "":
+		pem, err := os.ReadFile(*tlsCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("в %s нет сертификатов", *tlsCA)
+		}
+		config.RootCAs = pool
+
+	case *tlsPin != "":
+		pin := strings.ToLower(strings.ReplaceAll(*tlsPin, ":", ""))
+		// Цепочка не проверяется: доверие задаётся отпечатком
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("сервер не предъявил сертификат")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			if hex.EncodeToString(sum[:]) != pin {
+				return errors.New("отпечаток сертификата сервера не совпадает с -tls-pin")
+			}
+			return nil
+		}
+
+	default:
+		config.InsecureSkipVerify = true
+	}
+	return config, nil
+}
+
+// ReadMessage читает одно сообщение; для UDP buf должен вмещать protocol.MaxFrame
+func (c *controlConn) ReadMessage(buf []byte) (protocol.Message, error) {
+	if c.stream {
+		return protocol.ReadFrame(c.r)
+	}
+	n, err := c.Read(buf)
+	if err != nil {
+		return protocol.Message{}, err
+	}
+	return protocol.Decode(buf[:n])
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"airchat/protocol"
+)
+
+// typingInterval — как часто повторяется TYPING, пока пользователь
+// набирает текст; меньше срока показа на сервере, чтобы подсказка не мигала
+const typingInterval = 3 * time.Second
+
+// Последний отправленный TYPING; используются только горутиной ввода
+var (
+	typingSent    time.Time
+	typingChannel string
+)
+
+// noteTyping отправляет TYPING в текущий канал, пока набирается сообщение.
+// Команды набором сообщения не считаются.
+func noteTyping(line string) {
+	if line == "" {
+		// Строка отправлена или стёрта: следующее сообщение сообщит о наборе сразу
+		typingSent = time.Time{}
+		return
+	}
+	if strings.HasPrefix(line, "/") {
+		return
+	}
+	sendTyping()
+}
+
+// sendTyping отправляет TYPING в текущий канал не чаще typingInterval
+func sendTyping() {
+	channel, conn := currentChannel(), activeConn()
+	if channel == "" || conn == nil {
+		return
+	}
+	if channel == typingChannel && time.Since(typingSent) < typingInterval {
+		return
+	}
+	typingSent, typingChannel = time.Now(), channel
+	// Сигнал без подтверждения: потеря лишь ненадолго скрывает подсказку
+	sendFrame(conn, protocol.New(protocol.TypeTyping, channel))
+}
+
+// typing — кто набирает сообщение: канал → имя → до какого времени показывать
+var (
+	typingMu sync.Mutex
+	typing   = map[string]map[string]time.Time{}
+)
+
+// typingLabel — подсказка для приглашения ввода: кто набирает
+// сообщение в канале
+func typingLabel(channel string) string {
+	typingMu.Lock()
+	defer typingMu.Unlock()
+
+	var names []string
+	for name, until := range typing[channel] {
+		if time.Now().Before(until) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	switch len(names) {
+	case 0:
+		return ""
+	case 1:
+		return " (" + names[0] + " печатает…)"
+	}
+	return " (" + strings.Join(names, ", ") + " печатают…)"
+}
+
+// handleTyping показывает, что пользователь набирает сообщение
+// [канал, кто, сколько секунд показывать]; подсказка исчезает сама
+func handleTyping(msg protocol.Message) {
+	channel, name := msg.Field(0), msg.Field(1)
+	seconds, err := strconv.Atoi(msg.Field(2))
+	if err != nil || seconds <= 0 {
+		return
+	}
+	ttl := time.Duration(seconds) * time.Second
+
+	typingMu.Lock()
+	if typing[channel] == nil {
+		typing[channel] = map[string]time.Time{}
+	}
+	typing[channel][name] = time.Now().Add(ttl)
+	typingMu.Unlock()
+
+	redrawPrompt(channel)
+	time.AfterFunc(ttl, func() {
+		typingMu.Lock()
+		until, ok := typing[channel][name]
+		expired := ok && !time.Now().Before(until)
+		if expired {
+			delete(typing[channel], name)
+		}
+		typingMu.Unlock()
+		if expired {
+			redrawPrompt(channel)
+		}
+	})
+}
+
+// stopTyping убирает подсказку, когда сообщение пользователя уже пришло
+func stopTyping(channel, name string) {
+	typingMu.Lock()
+	delete(typing[channel], name)
+	typingMu.Unlock()
+}
+
+// redrawPrompt перерисовывает приглашение ввода, если изменилась
+// подсказка текущего канала
+func redrawPrompt(channel string) {
+	if channel == currentChannel() {
+		fmt.Printf("\r\033[K%s", prompt())
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"airchat/protocol"
+)
+
+type voicePeer struct {
+	username string
+	pub      []byte
+	identity []byte // ключ идентичности участника, nil — неизвестен
+}
+
+var (
+	voiceSealer atomic.Pointer[protocol.VoiceSealer] // nil, пока сервер не принял VOICE_CONNECT
+	voiceOpener = protocol.NewVoiceOpener()
+
+	voiceMu     sync.Mutex
+	voiceChan   string                   // голосовой канал, подтверждённый сервером
+	voicePriv   *ecdh.PrivateKey         // эфемерная пара текущего подключения к голосу
+	voiceMode   string                   // protocol.VoiceModeServer или protocol.VoiceModeE2E
+	serverPub   []byte                   // ключ сервера (режим сервера)
+	ownVoiceKey []byte                   // наш ключ отправителя (режим E2E)
+	voicePeers  = map[uint32]voicePeer{} // участники голосового чата по ssrc
+)
+
+// prepareVoiceCrypto создаёт новую пару ключей для VOICE_CONNECT
+// и забывает ключи предыдущего подключения
+func prepareVoiceCrypto() ([]byte, error) {
+	resetVoiceCrypto()
+
+	priv, err := protocol.NewVoiceKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	voiceMu.Lock()
+	voicePriv = priv
+	voiceMu.Unlock()
+	return priv.PublicKey().Bytes(), nil
+}
+
+// currentVoiceChannel возвращает голосовой канал пользователя
+func currentVoiceChannel() string {
+	voiceMu.Lock()
+	defer voiceMu.Unlock()
+	return voiceChan
+}
+
+// connectVoice запрашивает подключение к голосовому каналу с новой парой
+// ключей; пустой канал означает канал сервера по умолчанию. Если
+// пользователь уже в голосе, сервер переводит его в другой канал.
+func connectVoice(conn *controlConn, channel string) error {
+	voicePub, err := prepareVoiceCrypto()
+	if err != nil {
+		return err
+	}
+	return sendControl(conn, protocol.New(protocol.TypeVoiceConnect, string(voicePub), channel))
+}
+
+// rejoinVoice повторно подключается к голосовому каналу после
+// переподключения к серверу: сервер сбрасывает голос при смене соединения
+func rejoinVoice(conn *controlConn) {
+	if err := connectVoice(conn, currentVoiceChannel()); err != nil {
+		fmt.Printf("\r❌ Ошибка создания ключей голоса: %v\n%s", err, prompt())
+	}
+}
+
+func resetVoiceCrypto() {
+	voiceSealer.Store(nil)
+
+	voiceMu.Lock()
+	defer voiceMu.Unlock()
+	for ssrc := range voicePeers {
+		voiceOpener.Remove(ssrc)
+	}
+	voicePeers = map[uint32]voicePeer{}
+	voicePriv, voiceMode, serverPub, ownVoiceKey = nil, "", nil, nil
+}
+
+// leaveVoiceChannel забывает голосовой канал после отключения от голоса
+func leaveVoiceChannel() {
+	resetVoiceCrypto()
+	voiceMu.Lock()
+	voiceChan = ""
+	voiceMu.Unlock()
+}
+
+func parseSSRC(s string) (uint32, bool) {
+	v, err := strconv.ParseUint(s, 10, 32)
+	return uint32(v), err == nil && v != 0
+}
+
+// handleVoiceAccept получает ssrc и ключ отправителя: от сервера
+// в режиме сервера или создаёт собственный в режиме E2E
+func handleVoiceAccept(msg protocol.Message) {
+	ssrc, ok := parseSSRC(msg.Field(0))
+	if !ok {
+		return
+	}
+
+	voiceMu.Lock()
+	defer voiceMu.Unlock()
+	if voicePriv == nil {
+		return
+	}
+
+	var key []byte
+	var err error
+	voiceMode = msg.Field(1)
+	switch voiceMode {
+	case protocol.VoiceModeServer:
+		serverPub = []byte(msg.Field(2))
+		key, err = protocol.OpenVoiceKey(voicePriv, serverPub, []byte(msg.Field(3)))
+	case protocol.VoiceModeE2E:
+		key, err = protocol.NewVoiceKey()
+		ownVoiceKey = key
+	default:
+		err = fmt.Errorf("неизвестный режим %q", voiceMode)
+	}
+	if err != nil {
+		fmt.Printf("\r❌ Ошибка согласования ключа голоса: %v\n%s", err, prompt())
+		return
+	}
+
+	sealer, err := protocol.NewVoiceSealer(ssrc, key)
+	if err != nil {
+		fmt.Printf("\r❌ Ошибка согласования ключа голоса: %v\n%s", err, prompt())
+		return
+	}
+	voiceSealer.Store(sealer)
+	voiceChan = msg.Field(4)
+	fmt.Printf("\r🎤 Вы в голосовом канале %s\n%s", voiceChan, prompt())
+	if voiceMode == protocol.VoiceModeE2E {
+		fmt.Printf("\r🔒 Голос шифруется сквозным ключом\n%s", prompt())
+	}
+}
+
+// handleVoicePeer запоминает участника; в режиме E2E отправляет ему
+// наш ключ, зашифрованный его ключом голоса и ключом идентичности
+func handleVoicePeer(conn *controlConn, msg protocol.Message) {
+	ssrc, ok := parseSSRC(msg.Field(0))
+	if !ok {
+		return
+	}
+
+	peer := voicePeer{username: msg.Field(1), pub: []byte(msg.Field(2))}
+	peer.identity, _ = identityOf(peer.username)
+
+	voiceMu.Lock()
+	voicePeers[ssrc] = peer
+	priv, mode, key := voicePriv, voiceMode, ownVoiceKey
+	voiceMu.Unlock()
+
+	if mode != protocol.VoiceModeE2E || priv == nil || key == nil {
+		return
+	}
+	if peer.identity == nil {
+		// Без ключа идентичности нельзя убедиться, что ключ голоса
+		// участника не подменён сервером
+		fmt.Printf("\r❌ Ключ голоса %s не передан: неизвестен ключ идентичности\n%s", peer.username, prompt())
+		return
+	}
+	sealed, err := protocol.SealPeerVoiceKey(priv, peer.pub, identity, peer.identity, key)
+	if err != nil {
+		fmt.Printf("\r❌ Не удалось передать ключ голоса %s: %v\n%s", peer.username, err, prompt())
+		return
+	}
+	sendControl(conn, protocol.New(protocol.TypeVoiceKey, msg.Field(0), string(sealed)))
+}
+
+// handleVoiceKey расшифровывает ключ отправителя ssrc
+func handleVoiceKey(msg protocol.Message) {
+	ssrc, ok := parseSSRC(msg.Field(0))
+	if !ok {
+		return
+	}
+
+	voiceMu.Lock()
+	defer voiceMu.Unlock()
+	if voicePriv == nil {
+		return
+	}
+
+	// В режиме сервера ключ зашифрован сервером, в E2E — самим участником
+	var key []byte
+	var err error
+	if voiceMode == protocol.VoiceModeE2E {
+		peer, ok := voicePeers[ssrc]
+		if !ok {
+			return
+		}
+		if peer.identity == nil {
+			fmt.Printf("\r❌ Ключ голоса %s не принят: неизвестен ключ идентичности\n%s", peer.username, prompt())
+			return
+		}
+		key, err = protocol.OpenPeerVoiceKey(voicePriv, peer.pub, identity, peer.identity, []byte(msg.Field(1)))
+	} else {
+		key, err = protocol.OpenVoiceKey(voicePriv, serverPub, []byte(msg.Field(1)))
+	}
+	if err != nil {
+		fmt.Printf("\r❌ Не удалось расшифровать ключ голоса: %v\n%s", err, prompt())
+		return
+	}
+	voiceOpener.SetKey(ssrc, key)
+}
+
+func handleVoicePeerLeft(msg protocol.Message) {
+	ssrc, ok := parseSSRC(msg.Field(0))
+	if !ok {
+		return
+	}
+	voiceMu.Lock()
+	delete(voicePeers, ssrc)
+	voiceMu.Unlock()
+	voiceOpener.Remove(ssrc)
+}
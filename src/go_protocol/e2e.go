@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Сквозное шифрование чата построено на ключах отправителей: каждый
+// участник шифрует свои сообщения собственным ключом и раздаёт его
+// остальным, зашифровав X25519-ключом идентичности получателя
+// (TypeGroupKey). Вместе эти ключи образуют групповой ключ комнаты;
+// сервер пересылает TypeSealedChat, не имея возможности прочитать текст.
+
+const chatWrapLabel = "airchat chat key wrap"
+
+var ErrSealedChat = errors.New("protocol: sealed chat authentication failed")
+
+// ParseIdentityKey проверяет открытый ключ идентичности X25519.
+func ParseIdentityKey(pub []byte) (*ecdh.PublicKey, error) {
+	return ecdh.X25519().NewPublicKey(pub)
+}
+
+// Fingerprint возвращает отпечаток открытого ключа для сверки
+// по независимому каналу (/verify).
+func Fingerprint(pub []byte) string {
+	sum := sha256.Sum256(pub)
+	s := hex.EncodeToString(sum[:16])
+	groups := make([]string, 0, len(s)/4)
+	for i := 0; i < len(s); i += 4 {
+		groups = append(groups, s[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
+
+// NewChatKey создаёт ключ отправителя и его идентификатор.
+func NewChatKey() (id string, key []byte, err error) {
+	key = make([]byte, chacha20poly1305.KeySize)
+	if _, err = rand.Read(key); err != nil {
+		return "", nil, err
+	}
+	rawID := make([]byte, 8)
+	if _, err = rand.Read(rawID); err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(rawID), key, nil
+}
+
+// SealChatKey шифрует ключ отправителя для владельца ключа идентичности peerPub.
+func SealChatKey(identity *ecdh.PrivateKey, peerPub, key []byte) ([]byte, error) {
+	return sealKey(chatWrapLabel, key, dhPair{identity, peerPub})
+}
+
+// OpenChatKey расшифровывает ключ отправителя, полученный от владельца peerPub.
+func OpenChatKey(identity *ecdh.PrivateKey, peerPub, sealed []byte) ([]byte, error) {
+	return openKey(chatWrapLabel, sealed, dhPair{identity, peerPub})
+}
+
+// SealChat шифрует текст ключом отправителя; id ключа аутентифицируется.
+func SealChat(key []byte, id string, text string) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(text)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, []byte(text), []byte(id)), nil
+}
+
+// OpenChat расшифровывает сообщение, созданное SealChat.
+func OpenChat(key []byte, id string, sealed []byte) (string, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrSealedChat
+	}
+	text, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return "", ErrSealedChat
+	}
+	return string(text), nil
+}
@@ -0,0 +1,119 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"hash/crc32"
+	"strconv"
+)
+
+// Передача файлов идёт через сервер. Отправитель предлагает файл
+// (TypeFileOffer), получатель запрашивает части начиная с нужного
+// смещения (TypeFileAccept), отправитель присылает до FileWindow частей
+// (TypeFileChunk), после чего получатель запрашивает следующие. Повторный
+// запрос с того же смещения продолжает передачу после потери сообщений,
+// переподключения или перезапуска клиента. Каждая часть несёт CRC-32,
+// весь файл проверяется по SHA-256 из предложения.
+
+const (
+	// FileChunkSize — размер части файла в байтах.
+	FileChunkSize = 8 * 1024
+	// FileWindow — сколько частей отправляется на один запрос.
+	FileWindow = 8
+	// TransferIDSize — длина идентификатора передачи в байтах. Сервер
+	// принимает части только от отправителя, но идентификатор не должен
+	// угадываться и сам по себе.
+	TransferIDSize = 16
+)
+
+// FileOffer — предложение файла.
+type FileOffer struct {
+	ID     string // выбирает отправитель, см. NewTransferID
+	Sender string // заполняет сервер
+	Target string // имя получателя или #канал
+	Name   string
+	Size   int64
+	Sum    string // SHA-256 содержимого, hex
+}
+
+// Message упаковывает предложение: [id, отправитель, получатель, имя, размер, sha256].
+func (o FileOffer) Message() Message {
+	return New(TypeFileOffer, o.ID, o.Sender, o.Target, o.Name, strconv.FormatInt(o.Size, 10), o.Sum)
+}
+
+// ParseFileOffer разбирает сообщение FILE_OFFER.
+func ParseFileOffer(m Message) (FileOffer, error) {
+	if m.Type != TypeFileOffer || len(m.Fields) < 6 {
+		return FileOffer{}, ErrBadField
+	}
+	size, err := strconv.ParseInt(m.Field(4), 10, 64)
+	if err != nil || size < 0 {
+		return FileOffer{}, ErrBadField
+	}
+	return FileOffer{
+		ID:     m.Field(0),
+		Sender: m.Field(1),
+		Target: m.Field(2),
+		Name:   m.Field(3),
+		Size:   size,
+		Sum:    m.Field(5),
+	}, nil
+}
+
+// FileChunk — часть файла.
+type FileChunk struct {
+	ID string
+	// Peer — получатель части в сообщении от отправителя к серверу
+	// и отправитель в сообщении от сервера к получателю.
+	Peer   string
+	Offset int64
+	Data   []byte
+	Sum    string // ChunkSum открытых данных
+	// KeyID непуст, если Data зашифрованы ключом отправителя (E2E).
+	KeyID string
+}
+
+// Message упаковывает часть: [id, участник, смещение, данные, crc32, id ключа].
+func (c FileChunk) Message() Message {
+	return New(TypeFileChunk, c.ID, c.Peer, strconv.FormatInt(c.Offset, 10), string(c.Data), c.Sum, c.KeyID)
+}
+
+// ParseFileChunk разбирает сообщение FILE_CHUNK.
+func ParseFileChunk(m Message) (FileChunk, error) {
+	if m.Type != TypeFileChunk || len(m.Fields) < 5 {
+		return FileChunk{}, ErrBadField
+	}
+	offset, err := strconv.ParseInt(m.Field(2), 10, 64)
+	if err != nil || offset < 0 {
+		return FileChunk{}, ErrBadField
+	}
+	return FileChunk{
+		ID:     m.Field(0),
+		Peer:   m.Field(1),
+		Offset: offset,
+		Data:   []byte(m.Field(3)),
+		Sum:    m.Field(4),
+		KeyID:  m.Field(5),
+	}, nil
+}
+
+// ChunkSum возвращает контрольную сумму части файла (CRC-32, hex).
+func ChunkSum(data []byte) string {
+	return strconv.FormatUint(uint64(crc32.ChecksumIEEE(data)), 16)
+}
+
+// NewTransferID создаёт случайный идентификатор передачи
+// (TransferIDSize байт в hex).
+func NewTransferID() (string, error) {
+	raw := make([]byte, TransferIDSize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// ValidTransferID проверяет вид идентификатора передачи.
+func ValidTransferID(id string) bool {
+	raw, err := hex.DecodeString(id)
+	return err == nil && len(raw) == TransferIDSize
+}
@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestChunkSum(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		{nil, "0"},
+		{[]byte("a"), "e8b7be43"},
+		{[]byte("123456789"), "cbf43926"},
+		{[]byte("The quick brown fox jumps over the lazy dog"), "414fa339"},
+	}
+	for _, tt := range tests {
+		if got := ChunkSum(tt.data); got != tt.want {
+			t.Errorf("ChunkSum(%q) = %s, ожидалось %s", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestFileOfferRoundTrip(t *testing.T) {
+	in := FileOffer{ID: "00ff", Sender: "alice", Target: "#general", Name: "отчёт.pdf", Size: 1 << 20, Sum: "ab"}
+	got, err := ParseFileOffer(in.Message())
+	if err != nil || got != in {
+		t.Fatalf("получено %+v (%v), ожидалось %+v", got, err, in)
+	}
+
+	for _, size := range []string{"-1", "x", ""} {
+		m := New(TypeFileOffer, "id", "", "bob", "a.txt", size, "sum")
+		if _, err := ParseFileOffer(m); err == nil {
+			t.Errorf("размер %q принят", size)
+		}
+	}
+}
+
+func TestFileChunkRoundTrip(t *testing.T) {
+	tests := []FileChunk{
+		{ID: "id", Peer: "bob", Offset: 0, Data: []byte("data"), Sum: ChunkSum([]byte("data"))},
+		{ID: "id", Peer: "bob", Offset: 8192, Data: []byte{0, 1, 2, 255}, Sum: "1", KeyID: "k"},
+	}
+	for _, in := range tests {
+		data, err := Encode(in.Message())
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ParseFileChunk(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != in.ID || got.Peer != in.Peer || got.Offset != in.Offset ||
+			!bytes.Equal(got.Data, in.Data) || got.Sum != in.Sum || got.KeyID != in.KeyID {
+			t.Fatalf("получено %+v, ожидалось %+v", got, in)
+		}
+	}
+
+	if _, err := ParseFileChunk(New(TypeFileChunk, "id", "bob", "-5", "x", "1")); err == nil {
+		t.Error("отрицательное смещение принято")
+	}
+}
+
+func TestTransferID(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id, err := NewTransferID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ValidTransferID(id) || seen[id] {
+			t.Fatalf("некорректный или повторный идентификатор %q", id)
+		}
+		seen[id] = true
+	}
+	valid := strings.Repeat("ab", TransferIDSize)
+	for _, id := range []string{"", "abcd", "zz" + valid[2:], valid + "00"} {
+		if ValidTransferID(id) {
+			t.Errorf("идентификатор %q принят", id)
+		}
+	}
+}
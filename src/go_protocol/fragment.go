@@ -0,0 +1,198 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxDatagram — размер кадра, до которого сообщение отправляется целиком.
+	// Выбран с запасом под MTU, чтобы избежать IP-фрагментации.
+	MaxDatagram = 1200
+	// MaxFrame — наибольший возможный кадр; буферы чтения должны быть не меньше.
+	MaxFrame = HeaderSize + MaxPayload
+	// DefaultMaxMessage — лимит на размер собранного сообщения по умолчанию.
+	DefaultMaxMessage = 32 * 1024
+	// LimitMaxMessage — предел, который допускает формат кадра.
+	LimitMaxMessage = MaxPayload - 64
+
+	// служебные поля фрагмента (id, index, count) плюс длины полей
+	fragmentOverhead = 3*(2+10) + 2
+	// fragmentChunk — сколько байт сообщения несёт один фрагмент
+	fragmentChunk = MaxDatagram - HeaderSize - fragmentOverhead
+	// maxPendingGroups — сколько сообщений собирается одновременно;
+	// при переполнении отбрасывается самое старое
+	maxPendingGroups = 16
+	// fragmentTTL — сколько ждать недостающие фрагменты сообщения
+	fragmentTTL = 30 * time.Second
+)
+
+var ErrBadFragment = errors.New("protocol: malformed fragment")
+
+// MessageTooLargeError сообщает, что сообщение превышает допустимый размер.
+type MessageTooLargeError struct {
+	Size  int
+	Limit int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("protocol: message of %d bytes exceeds limit of %d bytes", e.Size, e.Limit)
+}
+
+// Fragmenter режет крупные сообщения на фрагменты TypeFragment.
+type Fragmenter struct {
+	mu     sync.Mutex
+	nextID uint32
+	limit  int
+}
+
+// NewFragmenter создаёт фрагментатор с лимитом limit байт на сообщение.
+func NewFragmenter(limit int) *Fragmenter {
+	return &Fragmenter{limit: limit}
+}
+
+// Encode кодирует сообщение целиком, проверяя лимит размера.
+// Используется для потоковых транспортов, где фрагментация не нужна.
+func (f *Fragmenter) Encode(m Message) ([]byte, error) {
+	data, err := Encode(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > f.limit {
+		return nil, &MessageTooLargeError{Size: len(data), Limit: f.limit}
+	}
+	return data, nil
+}
+
+// Split возвращает сообщение как есть, если его кадр не больше MaxDatagram,
+// иначе — последовательность фрагментов. Номер Seq у результата не задан.
+func (f *Fragmenter) Split(m Message) ([]Message, error) {
+	m.Seq = 0
+	data, err := f.Encode(m)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= MaxDatagram {
+		return []Message{m}, nil
+	}
+
+	f.mu.Lock()
+	f.nextID++
+	id := strconv.FormatUint(uint64(f.nextID), 10)
+	f.mu.Unlock()
+
+	chunk := fragmentChunk
+	count := (len(data) + chunk - 1) / chunk
+	parts := make([]Message, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		parts = append(parts, New(TypeFragment,
+			id, strconv.Itoa(i), strconv.Itoa(count), string(data[i*chunk:end])))
+	}
+	return parts, nil
+}
+
+type fragmentGroup struct {
+	parts    []string
+	got      []bool // какие части уже пришли (часть может быть пустой)
+	received int
+	size     int
+	created  time.Time
+}
+
+// Reassembler собирает сообщения из фрагментов одной сессии.
+type Reassembler struct {
+	mu     sync.Mutex
+	limit  int
+	groups map[string]*fragmentGroup
+}
+
+// NewReassembler создаёт сборщик с лимитом limit байт на сообщение.
+func NewReassembler(limit int) *Reassembler {
+	return &Reassembler{limit: limit, groups: make(map[string]*fragmentGroup)}
+}
+
+// Add принимает фрагмент. Когда пришли все части, возвращает собранное
+// сообщение и true. При превышении лимита группа отбрасывается
+// и возвращается *MessageTooLargeError. Группы, которые не собрались
+// за fragmentTTL, отбрасываются; если групп слишком много, самая старая
+// уступает место новой.
+func (r *Reassembler) Add(m Message) (Message, bool, error) {
+	if m.Type != TypeFragment || len(m.Fields) < 4 {
+		return Message{}, false, ErrBadFragment
+	}
+	id := m.Field(0)
+	index, err1 := strconv.Atoi(m.Field(1))
+	count, err2 := strconv.Atoi(m.Field(2))
+	// Число частей приходит из сети: больше, чем нужно для сообщения
+	// предельного размера, не бывает, и столько памяти не выделяется
+	maxCount := (r.limit + fragmentChunk - 1) / fragmentChunk
+	if err1 != nil || err2 != nil || count <= 0 || count > maxCount || index < 0 || index >= count {
+		return Message{}, false, ErrBadFragment
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, ok := r.groups[id]
+	if !ok {
+		r.evict(time.Now())
+		g = &fragmentGroup{parts: make([]string, count), got: make([]bool, count), created: time.Now()}
+		r.groups[id] = g
+	}
+	if len(g.parts) != count {
+		delete(r.groups, id)
+		return Message{}, false, ErrBadFragment
+	}
+	if g.got[index] {
+		return Message{}, false, nil
+	}
+
+	g.parts[index] = m.Field(3)
+	g.got[index] = true
+	g.received++
+	g.size += len(m.Field(3))
+	if g.size > r.limit {
+		delete(r.groups, id)
+		return Message{}, false, &MessageTooLargeError{Size: g.size, Limit: r.limit}
+	}
+	if g.received < count {
+		return Message{}, false, nil
+	}
+
+	delete(r.groups, id)
+	data := make([]byte, 0, g.size)
+	for _, p := range g.parts {
+		data = append(data, p...)
+	}
+	inner, err := Decode(data)
+	if err != nil {
+		return Message{}, false, err
+	}
+	if inner.Type == TypeFragment {
+		return Message{}, false, ErrBadFragment
+	}
+	return inner, true, nil
+}
+
+// evict отбрасывает просроченные группы, а если места всё равно нет —
+// самую старую. Вызывающий должен держать r.mu.
+func (r *Reassembler) evict(now time.Time) {
+	var oldest string
+	for id, g := range r.groups {
+		if now.Sub(g.created) > fragmentTTL {
+			delete(r.groups, id)
+		} else if oldest == "" || g.created.Before(r.groups[oldest].created) {
+			oldest = id
+		}
+	}
+	if len(r.groups) >= maxPendingGroups {
+		delete(r.groups, oldest)
+	}
+}
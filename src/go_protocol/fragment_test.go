@@ -0,0 +1,150 @@
+package protocol
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFragmentRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		parts int
+	}{
+		{"короткое", 10, 1},
+		{"чуть больше двух фрагментов", fragmentChunk * 2, 3},
+		{"несколько фрагментов", 5000, 5},
+		{"у предела", DefaultMaxMessage - 100, 29},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(TypeChat, "alice", strings.Repeat("я", tt.size/2), "#general")
+			parts, err := NewFragmenter(DefaultMaxMessage).Split(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parts) == 1 {
+				if parts[0].Type != TypeChat {
+					t.Fatalf("короткое сообщение фрагментировано: %v", parts[0].Type)
+				}
+				return
+			}
+			if len(parts) != tt.parts {
+				t.Fatalf("частей %d, ожидалось %d", len(parts), tt.parts)
+			}
+			// Части приходят в обратном порядке и с повторами
+			r := NewReassembler(DefaultMaxMessage)
+			for i := len(parts) - 1; i > 0; i-- {
+				for j := 0; j < 2; j++ {
+					if _, ok, err := r.Add(parts[i]); ok || err != nil {
+						t.Fatalf("часть %d: собрано %v, ошибка %v", i, ok, err)
+					}
+				}
+			}
+			got, ok, err := r.Add(parts[0])
+			if !ok || err != nil {
+				t.Fatalf("сообщение не собрано: %v", err)
+			}
+			if got.Type != m.Type || !slices.Equal(got.Fields, m.Fields) {
+				t.Fatalf("собрано не то сообщение: %v", got)
+			}
+		})
+	}
+}
+
+func TestFragmentTooLarge(t *testing.T) {
+	m := New(TypeChat, strings.Repeat("x", DefaultMaxMessage))
+	_, err := NewFragmenter(DefaultMaxMessage).Split(m)
+	var tooLarge *MessageTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("ожидалась MessageTooLargeError, получено %v", err)
+	}
+}
+
+func TestReassemblerMalformed(t *testing.T) {
+	maxCount := (DefaultMaxMessage + fragmentChunk - 1) / fragmentChunk
+	tests := []struct {
+		name   string
+		fields []string
+	}{
+		{"мало полей", []string{"1", "0", "2"}},
+		{"count не число", []string{"1", "0", "x", "a"}},
+		{"нулевой count", []string{"1", "0", "0", "a"}},
+		{"отрицательный count", []string{"1", "0", "-1", "a"}},
+		{"огромный count", []string{"1", "0", "2000000000", "a"}},
+		{"count сверх лимита", []string{"1", "0", strconv.Itoa(maxCount + 1), "a"}},
+		{"index за count", []string{"1", "2", "2", "a"}},
+		{"отрицательный index", []string{"1", "-1", "2", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReassembler(DefaultMaxMessage)
+			_, _, err := r.Add(New(TypeFragment, tt.fields...))
+			if !errors.Is(err, ErrBadFragment) {
+				t.Fatalf("ожидалась ErrBadFragment, получено %v", err)
+			}
+			if len(r.groups) != 0 {
+				t.Fatalf("некорректный фрагмент оставил группу")
+			}
+		})
+	}
+}
+
+func TestReassemblerCountMismatch(t *testing.T) {
+	r := NewReassembler(DefaultMaxMessage)
+	if _, _, err := r.Add(New(TypeFragment, "1", "0", "3", "a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.Add(New(TypeFragment, "1", "1", "2", "b")); !errors.Is(err, ErrBadFragment) {
+		t.Fatalf("ожидалась ErrBadFragment, получено %v", err)
+	}
+}
+
+func TestReassemblerEmptyPart(t *testing.T) {
+	// Пустая часть считается полученной: её повтор не засчитывается дважды
+	data, err := Encode(New(TypeChat, "hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewReassembler(DefaultMaxMessage)
+	for _, m := range []Message{
+		New(TypeFragment, "1", "0", "3", ""),
+		New(TypeFragment, "1", "0", "3", ""),
+		New(TypeFragment, "1", "1", "3", string(data[:3])),
+	} {
+		if _, ok, err := r.Add(m); ok || err != nil {
+			t.Fatalf("сообщение собрано раньше времени: %v %v", ok, err)
+		}
+	}
+	got, ok, err := r.Add(New(TypeFragment, "1", "2", "3", string(data[3:])))
+	if !ok || err != nil || got.Field(0) != "hi" {
+		t.Fatalf("сообщение не собрано: %v %v %v", got, ok, err)
+	}
+}
+
+func TestReassemblerEviction(t *testing.T) {
+	r := NewReassembler(DefaultMaxMessage)
+	// Незавершённые группы не блокируют новые сообщения
+	for i := 0; i < maxPendingGroups*2; i++ {
+		if _, _, err := r.Add(New(TypeFragment, strconv.Itoa(i), "0", "2", "a")); err != nil {
+			t.Fatalf("группа %d: %v", i, err)
+		}
+	}
+	if len(r.groups) != maxPendingGroups {
+		t.Fatalf("групп %d, ожидалось %d", len(r.groups), maxPendingGroups)
+	}
+
+	// Просроченные группы отбрасываются
+	for _, g := range r.groups {
+		g.created = g.created.Add(-2 * fragmentTTL)
+	}
+	if _, _, err := r.Add(New(TypeFragment, "new", "0", "2", "a")); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.groups) != 1 {
+		t.Fatalf("просроченные группы не отброшены: %d", len(r.groups))
+	}
+}
@@ -0,0 +1,7 @@
+module airchat/protocol
+
+go 1.21
+
+require golang.org/x/crypto v0.33.0
+
+require golang.org/x/sys v0.30.0 // indirect
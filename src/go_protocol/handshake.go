@@ -0,0 +1,148 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Кодеки голосового потока.
+const (
+	CodecOpus = "opus"
+)
+
+// Необязательные возможности, согласуемые при рукопожатии.
+const (
+	FeatureVoice    = "voice"     // голосовой чат на порту 6001
+	FeatureVoiceE2E = "voice-e2e" // сквозное шифрование голоса (см. VoiceModeE2E)
+	FeatureChatE2E  = "chat-e2e"  // сквозное шифрование текстового чата
+	FeatureFiles    = "files"     // передача файлов через сервер
+)
+
+var ErrBadHandshake = errors.New("protocol: malformed handshake")
+
+// Hello — первое сообщение клиента после подключения.
+type Hello struct {
+	Version  byte
+	Codecs   []string
+	Features []string
+	// Resume — сессия из прошлого WELCOME, которую клиент хочет продолжить
+	// после переподключения; пусто — новая сессия.
+	Resume string
+}
+
+// Message упаковывает Hello в управляющее сообщение.
+func (h Hello) Message() Message {
+	return New(TypeHello,
+		strconv.Itoa(int(h.Version)),
+		strings.Join(h.Codecs, ","),
+		strings.Join(h.Features, ","),
+		h.Resume)
+}
+
+// ParseHello разбирает сообщение HELLO.
+func ParseHello(m Message) (Hello, error) {
+	if m.Type != TypeHello || len(m.Fields) < 3 {
+		return Hello{}, ErrBadHandshake
+	}
+	v, err := parseVersion(m.Field(0))
+	if err != nil {
+		return Hello{}, err
+	}
+	return Hello{
+		Version:  v,
+		Codecs:   splitList(m.Field(1)),
+		Features: splitList(m.Field(2)),
+		Resume:   m.Field(3),
+	}, nil
+}
+
+// Welcome — ответ сервера на успешное рукопожатие.
+type Welcome struct {
+	Version    byte
+	Session    string
+	Features   []string
+	MaxMessage int // лимит сервера на размер сообщения, 0 — не сообщён
+	// Heartbeat — как часто клиент должен присылать PING, 0 — не сообщён.
+	// Клиент, молчащий дольше нескольких интервалов, отключается.
+	Heartbeat time.Duration
+	// Resumed — сервер продолжил сессию из Hello.Resume: клиент остаётся
+	// в чате и не должен повторять JOIN.
+	Resumed bool
+}
+
+// Message упаковывает Welcome в управляющее сообщение.
+func (w Welcome) Message() Message {
+	return New(TypeWelcome,
+		strconv.Itoa(int(w.Version)),
+		w.Session,
+		strings.Join(w.Features, ","),
+		strconv.Itoa(w.MaxMessage),
+		strconv.FormatInt(w.Heartbeat.Milliseconds(), 10),
+		strconv.FormatBool(w.Resumed))
+}
+
+// ParseWelcome разбирает сообщение WELCOME.
+func ParseWelcome(m Message) (Welcome, error) {
+	if m.Type != TypeWelcome || len(m.Fields) < 3 {
+		return Welcome{}, ErrBadHandshake
+	}
+	v, err := parseVersion(m.Field(0))
+	if err != nil {
+		return Welcome{}, err
+	}
+	maxMessage, _ := strconv.Atoi(m.Field(3))
+	heartbeat, _ := strconv.ParseInt(m.Field(4), 10, 64)
+	resumed, _ := strconv.ParseBool(m.Field(5))
+	return Welcome{
+		Version:    v,
+		Session:    m.Field(1),
+		Features:   splitList(m.Field(2)),
+		MaxMessage: maxMessage,
+		Heartbeat:  time.Duration(heartbeat) * time.Millisecond,
+		Resumed:    resumed,
+	}, nil
+}
+
+// Has сообщает, была ли возможность принята сервером.
+func (w Welcome) Has(feature string) bool {
+	return contains(w.Features, feature)
+}
+
+// Negotiate возвращает возможности из offered, которые есть в supported,
+// сохраняя порядок offered.
+func Negotiate(offered, supported []string) []string {
+	var accepted []string
+	for _, f := range offered {
+		if contains(supported, f) && !contains(accepted, f) {
+			accepted = append(accepted, f)
+		}
+	}
+	return accepted
+}
+
+func parseVersion(s string) (byte, error) {
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("%w: version %q", ErrBadHandshake, s)
+	}
+	return byte(v), nil
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
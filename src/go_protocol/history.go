@@ -0,0 +1,125 @@
+package protocol
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry — сообщение канала с идентификатором и временем, которые
+// назначил сервер. Так передаются сообщения из истории.
+type Entry struct {
+	Channel string
+	ID      uint64
+	Time    time.Time
+	Sender  string
+	Text    string
+	// KeyID непуст, если Text зашифрован ключом отправителя (E2E).
+	KeyID string
+	// Edited — сообщение изменялось после отправки.
+	Edited bool
+	// Reactions — количество каждой реакции на сообщение.
+	Reactions map[string]int
+	// Parent — сообщение, ответом на которое является это; пустое, если
+	// сообщение не ответ.
+	Parent Excerpt
+}
+
+// Message упаковывает Entry в сообщение HISTORY.
+func (e Entry) Message() Message {
+	edited := ""
+	if e.Edited {
+		edited = "1"
+	}
+	fields := []string{e.Channel, FormatID(e.ID), FormatTime(e.Time), e.Sender, e.Text, e.KeyID, edited,
+		FormatReactions(e.Reactions)}
+	return New(TypeHistory, append(fields, e.Parent.Fields()...)...)
+}
+
+// ParseEntry разбирает сообщение HISTORY.
+func ParseEntry(m Message) (Entry, error) {
+	if m.Type != TypeHistory || len(m.Fields) < 5 {
+		return Entry{}, ErrBadField
+	}
+	id, err := ParseID(m.Field(1))
+	if err != nil {
+		return Entry{}, err
+	}
+	t, err := ParseTime(m.Field(2))
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{
+		Channel:   m.Field(0),
+		ID:        id,
+		Time:      t,
+		Sender:    m.Field(3),
+		Text:      m.Field(4),
+		KeyID:     m.Field(5),
+		Edited:    m.Field(6) != "",
+		Reactions: ParseReactions(m.Field(7)),
+		Parent:    ParseExcerpt(m.Fields[min(8, len(m.Fields)):]),
+	}, nil
+}
+
+// FormatID кодирует идентификатор сообщения.
+func FormatID(id uint64) string {
+	return strconv.FormatUint(id, 10)
+}
+
+// ParseID разбирает идентификатор сообщения; 0 не бывает идентификатором.
+func ParseID(s string) (uint64, error) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || id == 0 {
+		return 0, ErrBadField
+	}
+	return id, nil
+}
+
+// FormatTime кодирует время как миллисекунды Unix.
+func FormatTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// ParseTime разбирает время, закодированное FormatTime.
+func ParseTime(s string) (time.Time, error) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrBadField
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// FormatReactions кодирует реакции как «реакция=количество» через пробел,
+// упорядоченные по реакции.
+func FormatReactions(r map[string]int) string {
+	tokens := make([]string, 0, len(r))
+	for t, n := range r {
+		if n > 0 {
+			tokens = append(tokens, t)
+		}
+	}
+	sort.Strings(tokens)
+	for i, t := range tokens {
+		tokens[i] = fmt.Sprintf("%s=%d", t, r[t])
+	}
+	return strings.Join(tokens, " ")
+}
+
+// ParseReactions разбирает реакции, закодированные FormatReactions;
+// некорректные элементы пропускаются.
+func ParseReactions(s string) map[string]int {
+	r := map[string]int{}
+	for _, item := range strings.Fields(s) {
+		i := strings.LastIndexByte(item, '=')
+		if i <= 0 {
+			continue
+		}
+		if n, err := strconv.Atoi(item[i+1:]); err == nil && n > 0 {
+			r[item[:i]] = n
+		}
+	}
+	return r
+}
@@ -0,0 +1,300 @@
+// Package protocol описывает бинарный управляющий протокол AirChat,
+// общий для go_server и go_client.
+//
+// Каждый кадр имеет вид:
+//
+//	+-------+---------+-----+-----------+-----------+----------------------+
+//	| "AC"  | версия  | тип | номер u32 | длина u16 | поля (u16 len + data)|
+//	+-------+---------+-----+-----------+-----------+----------------------+
+//
+// Номер 0 означает сообщение без гарантии доставки, ненулевой номер —
+// сообщение, которое получатель должен подтвердить (см. Sender и Receiver).
+// Все числа передаются в порядке big-endian.
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Version — текущая версия формата кадра.
+const Version byte = 2
+
+const (
+	// HeaderSize — размер заголовка кадра в байтах.
+	HeaderSize = 10
+	// MaxPayload — максимальная длина полезной нагрузки одного кадра.
+	MaxPayload = 0xFFFF
+)
+
+var magic = [2]byte{'A', 'C'}
+
+var (
+	ErrShortFrame = errors.New("protocol: short frame")
+	ErrBadMagic   = errors.New("protocol: bad magic")
+	ErrTooLarge   = errors.New("protocol: payload too large")
+	ErrBadField   = errors.New("protocol: malformed field")
+)
+
+// VersionError возвращается, если кадр закодирован другой версией протокола.
+type VersionError struct {
+	Got byte
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("protocol: unsupported version %d (want %d)", e.Got, Version)
+}
+
+// Type — вид управляющего сообщения.
+type Type byte
+
+const (
+	TypeJoin            Type = iota + 1 // клиент входит в чат: [имя, ключ идентичности]
+	TypeChat                            // текстовое сообщение: [текст, канал, id родителя], от сервера — [отправитель, текст, канал, id, время, родитель (см. Excerpt)...]
+	TypeVoiceConnect                    // клиент подключается к голосовому каналу: [открытый ключ X25519, канал]
+	TypeVoiceDisconnect                 // клиент отключился от голосового чата
+	TypeLeave                           // клиент покидает чат
+	TypeNotice                          // уведомление от сервера: [текст]
+	TypeHello                           // приветствие клиента: [версия, кодеки, возможности]
+	TypeWelcome                         // ответ сервера: [версия, сессия, возможности]
+	TypeReject                          // отказ сервера: [причина]
+	TypeAck                             // подтверждение сообщения с номером Seq
+	TypeFragment                        // часть крупного сообщения: [id, индекс, всего, данные]
+	TypeVoiceAccept                     // сервер принял VOICE_CONNECT: [ssrc, режим, ключ сервера, ключ голоса, канал]
+	TypeVoicePeer                       // участник голосового чата: [ssrc, имя, открытый ключ]
+	TypeVoiceKey                        // зашифрованный ключ голоса: [ssrc, ключ]
+	TypeVoicePeerLeft                   // участник покинул голосовой чат: [ssrc]
+	TypeIdentity                        // ключ идентичности участника: [имя, открытый ключ]
+	TypeGroupKey                        // ключ отправителя для E2E-чата: [имя, id ключа, ключ]
+	TypeSealedChat                      // сообщение E2E: [id ключа, шифротекст, канал, id родителя], от сервера — [отправитель, id ключа, шифротекст, канал, id, время, родитель...]
+	TypeRegister                        // регистрация учётной записи: [имя, пароль]
+	TypeLogin                           // вход в учётную запись: [имя, пароль]
+	TypeAuthOK                          // вход выполнен: [имя]
+	TypeNick                            // смена имени: [новое имя]
+	TypeRename                          // участник сменил имя: [старое имя, новое имя]
+	TypePing                            // проверка связи, без полей
+	TypePong                            // ответ на PING, без полей
+	TypeChannelJoin                     // вход в канал: [канал], от сервера — [канал, участники...]
+	TypeChannelPart                     // выход из канала: [канал]
+	TypeChannels                        // запрос списка каналов, от сервера — [канал, участники через пробел, ...]
+	TypeVoiceList                       // запрос списка голосовых каналов, ответ как у CHANNELS
+	TypeDirect                          // личное сообщение: [получатель, текст, id ключа], от сервера — [отправитель, текст, id ключа]
+	TypeHistory                         // запрос истории: [канал, до id, количество], от сервера — сообщение истории (см. Entry)
+	TypeSearch                          // поиск по истории: [запрос, канал, отправитель], от сервера — строка результата (см. SearchResult)
+	TypeEdit                            // правка сообщения: [id, текст, id ключа], от сервера — [канал, id, кто изменил, текст, id ключа]
+	TypeDelete                          // удаление сообщения: [id], от сервера — [канал, id, кто удалил]
+	TypeReact                           // реакция на сообщение (повтор снимает её): [id, реакция], от сервера — [канал, id, кто, реакция, +1 или -1, реакции (см. FormatReactions)]
+	TypeThread                          // запрос обсуждения: [id], от сервера — сообщение обсуждения (см. ThreadEntry)
+	TypeFileOffer                       // предложение файла, см. FileOffer
+	TypeFileAccept                      // запрос частей файла: [id, смещение], от сервера отправителю — [id, получатель, смещение]
+	TypeFileChunk                       // часть файла, см. FileChunk
+	TypeTyping                          // пользователь набирает сообщение (без подтверждения): [канал], от сервера — [канал, кто, сколько секунд показывать]
+	TypeStatus                          // статус присутствия: [состояние, текст] (см. StatusOnline), от сервера — [кто, состояние, текст]
+	TypeResync                          // сервер не смог доставить нумерованное сообщение (без подтверждения, без полей): клиент продолжает сессию заново
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeJoin:
+		return "JOIN"
+	case TypeChat:
+		return "CHAT"
+	case TypeVoiceConnect:
+		return "VOICE_CONNECT"
+	case TypeVoiceDisconnect:
+		return "VOICE_DISCONNECT"
+	case TypeLeave:
+		return "LEAVE"
+	case TypeNotice:
+		return "NOTICE"
+	case TypeHello:
+		return "HELLO"
+	case TypeWelcome:
+		return "WELCOME"
+	case TypeReject:
+		return "REJECT"
+	case TypeAck:
+		return "ACK"
+	case TypeFragment:
+		return "FRAGMENT"
+	case TypeVoiceAccept:
+		return "VOICE_ACCEPT"
+	case TypeVoicePeer:
+		return "VOICE_PEER"
+	case TypeVoiceKey:
+		return "VOICE_KEY"
+	case TypeVoicePeerLeft:
+		return "VOICE_PEER_LEFT"
+	case TypeIdentity:
+		return "IDENTITY"
+	case TypeGroupKey:
+		return "GROUP_KEY"
+	case TypeSealedChat:
+		return "SEALED_CHAT"
+	case TypeRegister:
+		return "REGISTER"
+	case TypeLogin:
+		return "LOGIN"
+	case TypeAuthOK:
+		return "AUTH_OK"
+	case TypeNick:
+		return "NICK"
+	case TypeRename:
+		return "RENAME"
+	case TypePing:
+		return "PING"
+	case TypePong:
+		return "PONG"
+	case TypeChannelJoin:
+		return "CHANNEL_JOIN"
+	case TypeChannelPart:
+		return "CHANNEL_PART"
+	case TypeChannels:
+		return "CHANNELS"
+	case TypeVoiceList:
+		return "VOICE_LIST"
+	case TypeDirect:
+		return "DIRECT"
+	case TypeHistory:
+		return "HISTORY"
+	case TypeSearch:
+		return "SEARCH"
+	case TypeEdit:
+		return "EDIT"
+	case TypeDelete:
+		return "DELETE"
+	case TypeReact:
+		return "REACT"
+	case TypeThread:
+		return "THREAD"
+	case TypeFileOffer:
+		return "FILE_OFFER"
+	case TypeFileAccept:
+		return "FILE_ACCEPT"
+	case TypeFileChunk:
+		return "FILE_CHUNK"
+	case TypeTyping:
+		return "TYPING"
+	case TypeStatus:
+		return "STATUS"
+	case TypeResync:
+		return "RESYNC"
+	}
+	return fmt.Sprintf("Type(%d)", byte(t))
+}
+
+// Message — декодированное управляющее сообщение.
+type Message struct {
+	Type   Type
+	Seq    uint32
+	Fields []string
+}
+
+// New собирает сообщение заданного типа из полей.
+func New(t Type, fields ...string) Message {
+	return Message{Type: t, Fields: fields}
+}
+
+// Field возвращает i-е поле или пустую строку, если его нет.
+func (m Message) Field(i int) string {
+	if i < 0 || i >= len(m.Fields) {
+		return ""
+	}
+	return m.Fields[i]
+}
+
+// Size возвращает размер кадра, в который Encode сериализует сообщение.
+func (m Message) Size() int {
+	size := HeaderSize
+	for _, f := range m.Fields {
+		size += 2 + len(f)
+	}
+	return size
+}
+
+// Encode сериализует сообщение в кадр.
+func Encode(m Message) ([]byte, error) {
+	size := 0
+	for _, f := range m.Fields {
+		if len(f) > 0xFFFF {
+			return nil, ErrTooLarge
+		}
+		size += 2 + len(f)
+	}
+	if size > MaxPayload {
+		return nil, ErrTooLarge
+	}
+
+	buf := make([]byte, HeaderSize, HeaderSize+size)
+	buf[0], buf[1] = magic[0], magic[1]
+	buf[2] = Version
+	buf[3] = byte(m.Type)
+	binary.BigEndian.PutUint32(buf[4:8], m.Seq)
+	binary.BigEndian.PutUint16(buf[8:10], uint16(size))
+	for _, f := range m.Fields {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(f)))
+		buf = append(buf, f...)
+	}
+	return buf, nil
+}
+
+// Decode разбирает один кадр.
+func Decode(data []byte) (Message, error) {
+	if len(data) < HeaderSize {
+		return Message{}, ErrShortFrame
+	}
+	if data[0] != magic[0] || data[1] != magic[1] {
+		return Message{}, ErrBadMagic
+	}
+	if data[2] != Version {
+		return Message{}, &VersionError{Got: data[2]}
+	}
+
+	m := Message{
+		Type: Type(data[3]),
+		Seq:  binary.BigEndian.Uint32(data[4:8]),
+	}
+	size := int(binary.BigEndian.Uint16(data[8:10]))
+	payload := data[HeaderSize:]
+	if len(payload) < size {
+		return Message{}, ErrShortFrame
+	}
+	payload = payload[:size]
+
+	for len(payload) > 0 {
+		if len(payload) < 2 {
+			return Message{}, ErrBadField
+		}
+		n := int(binary.BigEndian.Uint16(payload))
+		payload = payload[2:]
+		if len(payload) < n {
+			return Message{}, ErrBadField
+		}
+		m.Fields = append(m.Fields, string(payload[:n]))
+		payload = payload[n:]
+	}
+	return m, nil
+}
+
+// ReadFrame читает один кадр из потокового транспорта (TCP/TLS),
+// где границы кадров задаются полем длины в заголовке.
+func ReadFrame(r io.Reader) (Message, error) {
+	buf := make([]byte, HeaderSize, MaxFrame)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return Message{}, err
+	}
+	if buf[0] != magic[0] || buf[1] != magic[1] {
+		return Message{}, ErrBadMagic
+	}
+	if buf[2] != Version {
+		return Message{}, &VersionError{Got: buf[2]}
+	}
+	size := int(binary.BigEndian.Uint16(buf[8:10]))
+	buf = buf[:HeaderSize+size]
+	if _, err := io.ReadFull(r, buf[HeaderSize:]); err != nil {
+		return Message{}, err
+	}
+	return Decode(buf)
+}
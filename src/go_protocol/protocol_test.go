@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Message
+	}{
+		{"без полей", Message{Type: TypeLeave}},
+		{"с номером", Message{Type: TypeChat, Seq: 42, Fields: []string{"привет", "#general"}}},
+		{"пустые поля", New(TypeNotice, "", "", "x")},
+		{"двоичные данные", New(TypeVoiceConnect, "\x00\xff\x01", "#voice")},
+		{"предельное поле", New(TypeChat, strings.Repeat("x", MaxPayload-2))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Encode(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.in.Size() != len(data) {
+				t.Fatalf("Size %d, длина кадра %d", tt.in.Size(), len(data))
+			}
+			for _, read := range []func() (Message, error){
+				func() (Message, error) { return Decode(data) },
+				func() (Message, error) { return ReadFrame(bytes.NewReader(data)) },
+			} {
+				got, err := read()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Type != tt.in.Type || got.Seq != tt.in.Seq || !slices.Equal(got.Fields, tt.in.Fields) {
+					t.Fatalf("получено %v %d %q", got.Type, got.Seq, got.Fields)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeTooLarge(t *testing.T) {
+	tests := []Message{
+		New(TypeChat, strings.Repeat("x", MaxPayload-1)),
+		New(TypeChat, strings.Repeat("x", MaxPayload/2), strings.Repeat("x", MaxPayload/2)),
+	}
+	for _, m := range tests {
+		if _, err := Encode(m); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("ожидалась ErrTooLarge, получено %v", err)
+		}
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	valid, err := Encode(New(TypeChat, "hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch := func(i int, b byte) []byte {
+		data := slices.Clone(valid)
+		data[i] = b
+		return data
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"короткий заголовок", valid[:HeaderSize-1], ErrShortFrame},
+		{"неверная сигнатура", patch(0, 'X'), ErrBadMagic},
+		{"обрезанная нагрузка", valid[:len(valid)-1], ErrShortFrame},
+		{"обрезанная длина поля", patch(9, 1)[:HeaderSize+1], ErrBadField},
+		{"поле длиннее нагрузки", patch(HeaderSize+1, 9), ErrBadField},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.data); !errors.Is(err, tt.want) {
+				t.Fatalf("ожидалась %v, получено %v", tt.want, err)
+			}
+		})
+	}
+
+	var versionErr *VersionError
+	if _, err := Decode(patch(2, Version+1)); !errors.As(err, &versionErr) || versionErr.Got != Version+1 {
+		t.Fatalf("ожидалась VersionError, получено %v", err)
+	}
+}
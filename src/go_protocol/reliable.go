@@ -0,0 +1,185 @@
+package protocol
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// InitialRTO — задержка перед первой повторной отправкой.
+	InitialRTO = 200 * time.Millisecond
+	// MaxRTO — верхняя граница задержки при экспоненциальном росте.
+	MaxRTO = 5 * time.Second
+	// MaxAttempts — число отправок, после которого сообщение считается потерянным.
+	MaxAttempts = 8
+	// ReceiveWindow — сколько сообщений вперёд получатель готов буферизовать.
+	ReceiveWindow = 256
+)
+
+type pendingMessage struct {
+	msg      Message
+	data     []byte
+	attempts int
+	rto      time.Duration
+	next     time.Time
+}
+
+// Sender нумерует исходящие сообщения одной сессии и хранит их
+// до получения подтверждения.
+type Sender struct {
+	mu      sync.Mutex
+	seq     uint32
+	pending map[uint32]*pendingMessage
+}
+
+func NewSender() *Sender {
+	return &Sender{pending: make(map[uint32]*pendingMessage)}
+}
+
+// Prepare присваивает сообщению очередной номер и возвращает кадр
+// для первой отправки.
+func (s *Sender) Prepare(m Message) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	if s.seq == 0 {
+		// Номер 0 означает сообщение без подтверждения
+		s.seq = 1
+	}
+	m.Seq = s.seq
+	data, err := Encode(m)
+	if err != nil {
+		s.seq--
+		return nil, err
+	}
+	s.pending[m.Seq] = &pendingMessage{
+		msg:      m,
+		data:     data,
+		attempts: 1,
+		rto:      InitialRTO,
+		next:     time.Now().Add(InitialRTO),
+	}
+	return data, nil
+}
+
+// Ack снимает сообщение с повторной отправки.
+func (s *Sender) Ack(seq uint32) {
+	s.mu.Lock()
+	delete(s.pending, seq)
+	s.mu.Unlock()
+}
+
+// Due возвращает кадры, которые пора отправить повторно, и сообщения,
+// исчерпавшие MaxAttempts (они больше не отслеживаются). Получатель
+// ждёт номер потерянного сообщения, не обрабатывая следующие, поэтому
+// после потери обе стороны должны начать нумерацию заново — с новыми
+// Sender и Receiver (клиент для этого продолжает сессию, см. TypeResync).
+func (s *Sender) Due(now time.Time) (resend [][]byte, lost []Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for seq, p := range s.pending {
+		if now.Before(p.next) {
+			continue
+		}
+		if p.attempts >= MaxAttempts {
+			delete(s.pending, seq)
+			lost = append(lost, p.msg)
+			continue
+		}
+		p.attempts++
+		p.rto *= 2
+		if p.rto > MaxRTO {
+			p.rto = MaxRTO
+		}
+		p.next = now.Add(p.rto)
+		resend = append(resend, p.data)
+	}
+	return resend, lost
+}
+
+// Pending возвращает число неподтверждённых сообщений.
+func (s *Sender) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+// Receiver восстанавливает порядок входящих сообщений одной сессии
+// и отбрасывает дубликаты.
+type Receiver struct {
+	mu       sync.Mutex
+	expected uint32
+	buffered map[uint32]Message
+}
+
+func NewReceiver() *Receiver {
+	return &Receiver{expected: 1, buffered: make(map[uint32]Message)}
+}
+
+// Accept принимает нумерованное сообщение и возвращает сообщения,
+// которые теперь можно обработать по порядку. Дубликаты и сообщения
+// за пределами окна дают пустой результат.
+func (r *Receiver) Accept(m Message) []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Разность по модулю 2^32: после переполнения счётчика окно
+	// продолжается с 1, а старые номера дают большую разность
+	if m.Seq == 0 || m.Seq-r.expected >= ReceiveWindow {
+		return nil
+	}
+	if _, dup := r.buffered[m.Seq]; dup {
+		return nil
+	}
+	r.buffered[m.Seq] = m
+
+	var ready []Message
+	for {
+		next, ok := r.buffered[r.expected]
+		if !ok {
+			break
+		}
+		delete(r.buffered, r.expected)
+		ready = append(ready, next)
+		r.expected++
+		if r.expected == 0 {
+			r.expected = 1
+		}
+	}
+	return ready
+}
+
+// Drain возвращает буферизованные сообщения по порядку номеров, пропуская
+// недостающие, и очищает буфер. Нужен, когда недостающий номер потерян
+// окончательно: сообщения после него уже подтверждены, и отправитель
+// их не повторит.
+func (r *Receiver) Drain() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ready := make([]Message, 0, len(r.buffered))
+	for _, m := range r.buffered {
+		ready = append(ready, m)
+	}
+	// Порядок — по расстоянию от ожидаемого номера, с учётом переполнения
+	slices.SortFunc(ready, func(a, b Message) int {
+		return cmp.Compare(a.Seq-r.expected, b.Seq-r.expected)
+	})
+	clear(r.buffered)
+	return ready
+}
+
+// Reliable сообщает, требует ли сообщение подтверждения.
+// Сам ACK несёт номер подтверждаемого сообщения, но не подтверждается.
+func (m Message) Reliable() bool {
+	return m.Seq != 0 && m.Type != TypeAck
+}
+
+// AckFor возвращает подтверждение для нумерованного сообщения.
+func AckFor(m Message) Message {
+	return Message{Type: TypeAck, Seq: m.Seq}
+}
@@ -0,0 +1,170 @@
+package protocol
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReceiverAccept(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected uint32
+		in       []uint32 // номера в порядке прихода
+		out      []uint32 // номера, отданные на обработку
+	}{
+		{"по порядку", 1, []uint32{1, 2, 3}, []uint32{1, 2, 3}},
+		{"перестановка", 1, []uint32{3, 1, 2}, []uint32{1, 2, 3}},
+		{"дубликаты", 1, []uint32{1, 1, 2, 1, 2}, []uint32{1, 2}},
+		{"старые номера", 10, []uint32{5, 9, 10}, []uint32{10}},
+		{"за окном", 1, []uint32{ReceiveWindow + 1, 1}, []uint32{1}},
+		{"край окна", 1, []uint32{ReceiveWindow, 1}, []uint32{1}},
+		{"без номера", 1, []uint32{0, 1}, []uint32{1}},
+		{"переполнение", math.MaxUint32 - 1, []uint32{1, math.MaxUint32, math.MaxUint32 - 1, 2},
+			[]uint32{math.MaxUint32 - 1, math.MaxUint32, 1, 2}},
+		{"старые после переполнения", 2, []uint32{math.MaxUint32, 1, 2}, []uint32{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReceiver()
+			r.expected = tt.expected
+			var got []uint32
+			for _, seq := range tt.in {
+				for _, m := range r.Accept(Message{Type: TypeChat, Seq: seq}) {
+					got = append(got, m.Seq)
+				}
+			}
+			if !slices.Equal(got, tt.out) {
+				t.Fatalf("обработаны %v, ожидалось %v", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestSenderSequence(t *testing.T) {
+	s := NewSender()
+	s.seq = math.MaxUint32 - 1
+	var got []uint32
+	for i := 0; i < 3; i++ {
+		data, err := s.Prepare(New(TypeChat, "hi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, m.Seq)
+	}
+	// Номер 0 пропускается: он означает сообщение без подтверждения
+	if want := []uint32{math.MaxUint32, 1, 2}; !slices.Equal(got, want) {
+		t.Fatalf("номера %v, ожидалось %v", got, want)
+	}
+	if s.Pending() != 3 {
+		t.Fatalf("неподтверждённых %d, ожидалось 3", s.Pending())
+	}
+	s.Ack(1)
+	s.Ack(1)
+	if s.Pending() != 2 {
+		t.Fatalf("после ACK неподтверждённых %d, ожидалось 2", s.Pending())
+	}
+}
+
+func TestSenderDue(t *testing.T) {
+	s := NewSender()
+	if _, err := s.Prepare(New(TypeChat, "hi")); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if resend, lost := s.Due(now); len(resend) != 0 || len(lost) != 0 {
+		t.Fatalf("повтор до истечения RTO: %d %d", len(resend), len(lost))
+	}
+	for attempt := 2; attempt <= MaxAttempts; attempt++ {
+		now = now.Add(MaxRTO)
+		if resend, _ := s.Due(now); len(resend) != 1 {
+			t.Fatalf("попытка %d: повторов %d", attempt, len(resend))
+		}
+	}
+	now = now.Add(MaxRTO)
+	if resend, lost := s.Due(now); len(resend) != 0 || len(lost) != 1 || lost[0].Field(0) != "hi" {
+		t.Fatalf("сообщение не признано потерянным: %d %v", len(resend), lost)
+	}
+	if s.Pending() != 0 {
+		t.Fatalf("потерянное сообщение осталось в очереди")
+	}
+}
+
+func TestGiveUpAndResume(t *testing.T) {
+	s, r := NewSender(), NewReceiver()
+	var frames [][]byte
+	for _, text := range []string{"потеряно", "два", "три"} {
+		data, err := s.Prepare(New(TypeChat, text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, data)
+	}
+	// Первое сообщение не доходит ни разу, следующие ждут его в буфере
+	for _, data := range frames[1:] {
+		m, err := Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ready := r.Accept(m); len(ready) != 0 {
+			t.Fatalf("обработано до пропущенного номера: %v", ready)
+		}
+		s.Ack(m.Seq)
+	}
+
+	now := time.Now()
+	var lost []Message
+	for i := 0; i < MaxAttempts && len(lost) == 0; i++ {
+		now = now.Add(MaxRTO)
+		_, lost = s.Due(now)
+	}
+	if len(lost) != 1 || lost[0].Field(0) != "потеряно" {
+		t.Fatalf("потерянные: %v", lost)
+	}
+
+	// Подтверждённые сообщения после потерянного не пропадают
+	var got []string
+	for _, m := range r.Drain() {
+		got = append(got, m.Field(0))
+	}
+	if want := []string{"два", "три"}; !slices.Equal(got, want) {
+		t.Fatalf("из буфера получено %v, ожидалось %v", got, want)
+	}
+
+	// Сессия продолжается с новой нумерацией, и сообщения снова доходят
+	s, r = NewSender(), NewReceiver()
+	data, err := s.Prepare(New(TypeChat, "после"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ready := r.Accept(m); len(ready) != 1 || ready[0].Field(0) != "после" {
+		t.Fatalf("сообщение после возобновления не обработано: %v", ready)
+	}
+}
+
+func TestReceiverDrainWraparound(t *testing.T) {
+	r := NewReceiver()
+	r.expected = math.MaxUint32 - 1
+	for _, seq := range []uint32{2, math.MaxUint32, 1} {
+		r.Accept(Message{Type: TypeChat, Seq: seq})
+	}
+	var got []uint32
+	for _, m := range r.Drain() {
+		got = append(got, m.Seq)
+	}
+	if want := []uint32{math.MaxUint32, 1, 2}; !slices.Equal(got, want) {
+		t.Fatalf("порядок %v, ожидалось %v", got, want)
+	}
+	if len(r.Drain()) != 0 {
+		t.Fatal("буфер не очищен")
+	}
+}
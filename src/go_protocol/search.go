@@ -0,0 +1,28 @@
+package protocol
+
+// SearchResult упаковывает строку результата поиска в сообщение SEARCH.
+// hit — идентификатор найденного сообщения: у самой найденной строки
+// e.ID == hit, остальные строки с тем же hit — контекст вокруг неё.
+func SearchResult(hit uint64, e Entry) Message {
+	return New(TypeSearch, FormatID(hit), e.Channel, FormatID(e.ID), FormatTime(e.Time), e.Sender, e.Text)
+}
+
+// ParseSearchResult разбирает строку результата поиска.
+func ParseSearchResult(m Message) (hit uint64, e Entry, err error) {
+	if m.Type != TypeSearch || len(m.Fields) < 6 {
+		return 0, Entry{}, ErrBadField
+	}
+	if hit, err = ParseID(m.Field(0)); err != nil {
+		return 0, Entry{}, err
+	}
+	if e.ID, err = ParseID(m.Field(2)); err != nil {
+		return 0, Entry{}, err
+	}
+	if e.Time, err = ParseTime(m.Field(3)); err != nil {
+		return 0, Entry{}, err
+	}
+	e.Channel = m.Field(1)
+	e.Sender = m.Field(4)
+	e.Text = m.Field(5)
+	return hit, e, nil
+}
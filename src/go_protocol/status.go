@@ -0,0 +1,22 @@
+package protocol
+
+import "unicode/utf8"
+
+// Состояния присутствия пользователя (см. TypeStatus).
+const (
+	StatusOnline = "online" // в сети
+	StatusAway   = "away"   // отошёл; клиент ставит его сам после простоя
+	StatusDND    = "dnd"    // не беспокоить
+)
+
+// MaxStatusText — предельная длина текста статуса в символах.
+const MaxStatusText = 100
+
+// ValidStatus проверяет состояние присутствия и текст к нему.
+func ValidStatus(state, text string) bool {
+	switch state {
+	case StatusOnline, StatusAway, StatusDND:
+		return utf8.ValidString(text) && utf8.RuneCountInString(text) <= MaxStatusText
+	}
+	return false
+}
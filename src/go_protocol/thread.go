@@ -0,0 +1,87 @@
+package protocol
+
+import "unicode/utf8"
+
+// ExcerptLength — сколько символов родительского сообщения показывается
+// рядом с ответом.
+const ExcerptLength = 60
+
+// Excerpt — сообщение, на которое отвечают, для показа рядом с ответом.
+// Передаётся полями [id, отправитель, текст, id ключа] в конце CHAT,
+// SEALED_CHAT и HISTORY.
+type Excerpt struct {
+	ID     uint64
+	Sender string
+	// Text — начало текста; пустой у удалённого сообщения. Зашифрованный
+	// текст (KeyID непуст) передаётся целиком: сервер не может его сократить.
+	Text  string
+	KeyID string
+}
+
+// Fields кодирует Excerpt; у сообщения, которое не является ответом
+// (ID == 0), полей нет.
+func (x Excerpt) Fields() []string {
+	if x.ID == 0 {
+		return nil
+	}
+	return []string{FormatID(x.ID), x.Sender, x.Text, x.KeyID}
+}
+
+// ParseExcerpt разбирает поля, закодированные Fields; если полей нет
+// или они некорректны, возвращается пустой Excerpt.
+func ParseExcerpt(fields []string) Excerpt {
+	if len(fields) == 0 {
+		return Excerpt{}
+	}
+	id, err := ParseID(fields[0])
+	if err != nil {
+		return Excerpt{}
+	}
+	x := Excerpt{ID: id}
+	if len(fields) >= 4 {
+		x.Sender, x.Text, x.KeyID = fields[1], fields[2], fields[3]
+	}
+	return x
+}
+
+// Shorten обрезает текст до n символов, отмечая обрезку многоточием.
+func Shorten(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:n]) + "…"
+}
+
+// ThreadEntry упаковывает сообщение обсуждения с корнем root в сообщение
+// THREAD: [корень, канал, id, время, отправитель, текст, id ключа, id родителя].
+func ThreadEntry(root uint64, e Entry) Message {
+	parent := ""
+	if e.Parent.ID != 0 {
+		parent = FormatID(e.Parent.ID)
+	}
+	return New(TypeThread, FormatID(root), e.Channel, FormatID(e.ID), FormatTime(e.Time), e.Sender, e.Text,
+		e.KeyID, parent)
+}
+
+// ParseThreadEntry разбирает сообщение THREAD; у Parent заполнен только ID.
+func ParseThreadEntry(m Message) (root uint64, e Entry, err error) {
+	if m.Type != TypeThread || len(m.Fields) < 6 {
+		return 0, Entry{}, ErrBadField
+	}
+	if root, err = ParseID(m.Field(0)); err != nil {
+		return 0, Entry{}, err
+	}
+	if e.ID, err = ParseID(m.Field(2)); err != nil {
+		return 0, Entry{}, err
+	}
+	if e.Time, err = ParseTime(m.Field(3)); err != nil {
+		return 0, Entry{}, err
+	}
+	e.Channel = m.Field(1)
+	e.Sender = m.Field(4)
+	e.Text = m.Field(5)
+	e.KeyID = m.Field(6)
+	e.Parent = ParseExcerpt([]string{m.Field(7)})
+	return root, e, nil
+}
@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExcerptRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Excerpt
+	}{
+		{"не ответ", Excerpt{}},
+		{"открытый текст", Excerpt{ID: 5, Sender: "alice", Text: "привет"}},
+		{"шифротекст", Excerpt{ID: 7, Sender: "bob", Text: "\x00\x01шифр", KeyID: "k1"}},
+		{"удалённое сообщение", Excerpt{ID: 9, Sender: "bob"}},
+		{"сообщение не найдено", Excerpt{ID: 11}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseExcerpt(tt.in.Fields()); got != tt.in {
+				t.Fatalf("получено %+v, ожидалось %+v", got, tt.in)
+			}
+		})
+	}
+}
+
+func TestParseExcerptMalformed(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		want   Excerpt
+	}{
+		{"нет полей", nil, Excerpt{}},
+		{"некорректный id", []string{"x", "alice", "текст", ""}, Excerpt{}},
+		{"только id", []string{"3"}, Excerpt{ID: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseExcerpt(tt.fields); got != tt.want {
+				t.Fatalf("получено %+v, ожидалось %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShorten(t *testing.T) {
+	tests := []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"коротко", 10, "коротко"},
+		{"ровно", 5, "ровно"},
+		{"длинный текст", 7, "длинный…"},
+		{"👍👍👍", 2, "👍👍…"},
+		{"", 3, ""},
+	}
+	for _, tt := range tests {
+		if got := Shorten(tt.text, tt.n); got != tt.want {
+			t.Errorf("Shorten(%q, %d) = %q, ожидалось %q", tt.text, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestThreadEntryRoundTrip(t *testing.T) {
+	e := Entry{
+		Channel: "#general",
+		ID:      12,
+		Time:    time.UnixMilli(1700000000123),
+		Sender:  "alice",
+		Text:    "ответ",
+		KeyID:   "k",
+		Parent:  Excerpt{ID: 10},
+	}
+	root, got, err := ParseThreadEntry(ThreadEntry(3, e))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != 3 || got.Channel != e.Channel || got.ID != e.ID || !got.Time.Equal(e.Time) ||
+		got.Sender != e.Sender || got.Text != e.Text || got.KeyID != e.KeyID || got.Parent != e.Parent {
+		t.Fatalf("получено %d %+v, ожидалось 3 %+v", root, got, e)
+	}
+
+	if _, _, err := ParseThreadEntry(New(TypeThread, "1", "#general")); err == nil {
+		t.Fatal("короткое сообщение THREAD разобрано без ошибки")
+	}
+}
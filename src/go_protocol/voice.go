@@ -0,0 +1,300 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Голосовой пакет на порту 6001:
+//
+//	+-----------+----------+----------+-------------------------------+
+//	| версия u8 | ssrc u32 | номер u64| Opus, зашифрованный AEAD + tag |
+//	+-----------+----------+----------+-------------------------------+
+//
+// Шифр — ChaCha20-Poly1305 с ключом отправителя; nonce = ssrc || номер,
+// заголовок входит в аутентифицируемые данные.
+const (
+	VoicePacketVersion byte = 1
+	VoiceHeaderSize         = 13
+	VoiceKeySize            = chacha20poly1305.KeySize
+	// VoiceReplayWindow — сколько последних номеров помнит получатель.
+	VoiceReplayWindow = 64
+)
+
+// Режимы шифрования голоса, сообщаемые в VOICE_ACCEPT.
+const (
+	// VoiceModeServer: ключи раздаёт сервер, он же проверяет пакеты.
+	VoiceModeServer = "server"
+	// VoiceModeE2E: клиенты обмениваются ключами напрямую, сервер
+	// пересылает пакеты, не имея возможности их расшифровать. Ключи
+	// заверяются ключами идентичности участников (SealPeerVoiceKey):
+	// подменить их сервер может, только подменив и ключ идентичности,
+	// а это видно по /verify.
+	VoiceModeE2E = "e2e"
+)
+
+var (
+	ErrVoicePacket = errors.New("protocol: malformed voice packet")
+	ErrVoiceKey    = errors.New("protocol: unknown voice sender")
+	ErrVoiceReplay = errors.New("protocol: replayed voice packet")
+	ErrVoiceAuth   = errors.New("protocol: voice packet authentication failed")
+	ErrBadKey      = errors.New("protocol: malformed sealed key")
+)
+
+// VoiceSSRC возвращает идентификатор отправителя из заголовка пакета.
+func VoiceSSRC(packet []byte) (uint32, error) {
+	if len(packet) < VoiceHeaderSize || packet[0] != VoicePacketVersion {
+		return 0, ErrVoicePacket
+	}
+	return binary.BigEndian.Uint32(packet[1:5]), nil
+}
+
+// NewVoiceKey создаёт случайный ключ отправителя.
+func NewVoiceKey() ([]byte, error) {
+	key := make([]byte, VoiceKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// VoiceSealer шифрует исходящие пакеты одного отправителя.
+type VoiceSealer struct {
+	mu   sync.Mutex
+	ssrc uint32
+	seq  uint64
+	aead cipher.AEAD
+}
+
+func NewVoiceSealer(ssrc uint32, key []byte) (*VoiceSealer, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return &VoiceSealer{ssrc: ssrc, aead: aead}, nil
+}
+
+// Seal дописывает в dst зашифрованный пакет с очередным номером.
+func (s *VoiceSealer) Seal(dst, payload []byte) []byte {
+	s.mu.Lock()
+	s.seq++
+	seq := s.seq
+	s.mu.Unlock()
+
+	header := make([]byte, VoiceHeaderSize)
+	header[0] = VoicePacketVersion
+	binary.BigEndian.PutUint32(header[1:5], s.ssrc)
+	binary.BigEndian.PutUint64(header[5:13], seq)
+
+	dst = append(dst, header...)
+	return s.aead.Seal(dst, voiceNonce(header), payload, header)
+}
+
+func voiceNonce(header []byte) []byte {
+	return header[1:VoiceHeaderSize][:chacha20poly1305.NonceSize]
+}
+
+// replayWindow отбрасывает повторы и слишком старые номера.
+type replayWindow struct {
+	top    uint64
+	bitmap uint64 // бит i — получен номер top-i
+}
+
+func (w *replayWindow) check(seq uint64) bool {
+	switch {
+	case seq == 0:
+		return false
+	case seq > w.top:
+		return true
+	case w.top-seq >= VoiceReplayWindow:
+		return false
+	}
+	return w.bitmap&(1<<(w.top-seq)) == 0
+}
+
+func (w *replayWindow) mark(seq uint64) {
+	if seq > w.top {
+		shift := seq - w.top
+		if shift >= VoiceReplayWindow {
+			w.bitmap = 0
+		} else {
+			w.bitmap <<= shift
+		}
+		w.top = seq
+	}
+	w.bitmap |= 1 << (w.top - seq)
+}
+
+type voiceSender struct {
+	aead   cipher.AEAD
+	window replayWindow
+}
+
+// VoiceOpener проверяет и расшифровывает пакеты известных отправителей.
+type VoiceOpener struct {
+	mu      sync.Mutex
+	senders map[uint32]*voiceSender
+}
+
+func NewVoiceOpener() *VoiceOpener {
+	return &VoiceOpener{senders: make(map[uint32]*voiceSender)}
+}
+
+// SetKey задаёт ключ отправителя ssrc, сбрасывая окно повторов.
+func (o *VoiceOpener) SetKey(ssrc uint32, key []byte) error {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	o.senders[ssrc] = &voiceSender{aead: aead}
+	o.mu.Unlock()
+	return nil
+}
+
+// Remove забывает ключ отправителя.
+func (o *VoiceOpener) Remove(ssrc uint32) {
+	o.mu.Lock()
+	delete(o.senders, ssrc)
+	o.mu.Unlock()
+}
+
+// Open проверяет пакет и возвращает отправителя и расшифрованные данные.
+func (o *VoiceOpener) Open(dst, packet []byte) (uint32, []byte, error) {
+	ssrc, err := VoiceSSRC(packet)
+	if err != nil {
+		return 0, nil, err
+	}
+	header := packet[:VoiceHeaderSize]
+	seq := binary.BigEndian.Uint64(header[5:13])
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	s, ok := o.senders[ssrc]
+	if !ok {
+		return ssrc, nil, ErrVoiceKey
+	}
+	if !s.window.check(seq) {
+		return ssrc, nil, ErrVoiceReplay
+	}
+	payload, err := s.aead.Open(dst, voiceNonce(header), packet[VoiceHeaderSize:], header)
+	if err != nil {
+		return ssrc, nil, ErrVoiceAuth
+	}
+	s.window.mark(seq)
+	return ssrc, payload, nil
+}
+
+// NewVoiceKeyPair создаёт эфемерную пару X25519 для обмена ключами голоса.
+func NewVoiceKeyPair() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// dhPair — своя закрытая и чужая открытая половины одного обмена X25519.
+type dhPair struct {
+	priv    *ecdh.PrivateKey
+	peerPub []byte
+}
+
+// wrapCipher выводит из обменов X25519 общий ключ для шифрования ключей.
+// Метка и открытые ключи всех пар входят в контекст, поэтому результат
+// одинаков на обеих сторонах и привязан к этим парам и назначению.
+func wrapCipher(label string, pairs ...dhPair) (cipher.AEAD, error) {
+	var secret []byte
+	info := []byte(label)
+	for _, p := range pairs {
+		pub, err := ecdh.X25519().NewPublicKey(p.peerPub)
+		if err != nil {
+			return nil, err
+		}
+		shared, err := p.priv.ECDH(pub)
+		if err != nil {
+			return nil, err
+		}
+		secret = append(secret, shared...)
+
+		a, b := p.priv.PublicKey().Bytes(), p.peerPub
+		if bytes.Compare(a, b) > 0 {
+			a, b = b, a
+		}
+		info = append(info, a...)
+		info = append(info, b...)
+	}
+
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, info), key); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+// SealVoiceKey шифрует ключ голоса для владельца peerPub.
+func SealVoiceKey(priv *ecdh.PrivateKey, peerPub, key []byte) ([]byte, error) {
+	return sealKey(voiceWrapLabel, key, dhPair{priv, peerPub})
+}
+
+// OpenVoiceKey расшифровывает ключ голоса, полученный от владельца peerPub.
+func OpenVoiceKey(priv *ecdh.PrivateKey, peerPub, sealed []byte) ([]byte, error) {
+	return openKey(voiceWrapLabel, sealed, dhPair{priv, peerPub})
+}
+
+// SealPeerVoiceKey шифрует ключ голоса для участника в режиме E2E.
+// Ключ обёртки выводится из двух обменов — эфемерными ключами голоса
+// (priv, peerPub) и ключами идентичности (identity, peerIdentity), — поэтому
+// сервер, подменивший ключ голоса в VOICE_PEER, ключ не расшифрует:
+// для этого ему нужен закрытый ключ идентичности одного из участников.
+func SealPeerVoiceKey(priv *ecdh.PrivateKey, peerPub []byte, identity *ecdh.PrivateKey, peerIdentity, key []byte) ([]byte, error) {
+	return sealKey(peerVoiceWrapLabel, key, dhPair{priv, peerPub}, dhPair{identity, peerIdentity})
+}
+
+// OpenPeerVoiceKey расшифровывает ключ голоса, созданный SealPeerVoiceKey.
+// Успех означает, что ключ прислал владелец ключа идентичности peerIdentity.
+func OpenPeerVoiceKey(priv *ecdh.PrivateKey, peerPub []byte, identity *ecdh.PrivateKey, peerIdentity, sealed []byte) ([]byte, error) {
+	return openKey(peerVoiceWrapLabel, sealed, dhPair{priv, peerPub}, dhPair{identity, peerIdentity})
+}
+
+const (
+	voiceWrapLabel     = "airchat voice key wrap"
+	peerVoiceWrapLabel = "airchat peer voice key wrap"
+)
+
+func sealKey(label string, key []byte, pairs ...dhPair) ([]byte, error) {
+	aead, err := wrapCipher(label, pairs...)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(key)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, key, nil), nil
+}
+
+func openKey(label string, sealed []byte, pairs ...dhPair) ([]byte, error) {
+	aead, err := wrapCipher(label, pairs...)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrBadKey
+	}
+	key, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != chacha20poly1305.KeySize {
+		return nil, ErrBadKey
+	}
+	return key, nil
+}
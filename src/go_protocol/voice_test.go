@@ -0,0 +1,59 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"testing"
+)
+
+func mustKeyPair(t *testing.T) *ecdh.PrivateKey {
+	t.Helper()
+	priv, err := NewVoiceKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv
+}
+
+func TestPeerVoiceKey(t *testing.T) {
+	alice, bob := mustKeyPair(t), mustKeyPair(t)       // ключи голоса
+	aliceID, bobID := mustKeyPair(t), mustKeyPair(t)   // ключи идентичности
+	server, serverID := mustKeyPair(t), mustKeyPair(t) // ключи подменившего сервера
+	pub := func(k *ecdh.PrivateKey) []byte { return k.PublicKey().Bytes() }
+
+	key, err := NewVoiceKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := SealPeerVoiceKey(alice, pub(bob), aliceID, pub(bobID), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := OpenPeerVoiceKey(bob, pub(alice), bobID, pub(aliceID), sealed)
+	if err != nil || !bytes.Equal(got, key) {
+		t.Fatalf("ключ не расшифрован: %v", err)
+	}
+
+	// Сервер подменил ключ голоса alice в VOICE_PEER своим: без закрытого
+	// ключа идентичности alice или bob ключ, зашифрованный для bob, не
+	// расшифровать, а свой — bob не примет
+	if _, err := OpenPeerVoiceKey(server, pub(bob), serverID, pub(bobID), sealed); err == nil {
+		t.Fatal("ключ расшифрован без ключа идентичности")
+	}
+	forged, err := SealPeerVoiceKey(server, pub(bob), serverID, pub(bobID), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenPeerVoiceKey(bob, pub(server), bobID, pub(aliceID), forged); err == nil {
+		t.Fatal("принят ключ, не заверенный ключом идентичности alice")
+	}
+
+	// Ключ без заверения (как в режиме сервера) в режиме E2E не принимается
+	plain, err := SealVoiceKey(alice, pub(bob), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenPeerVoiceKey(bob, pub(alice), bobID, pub(aliceID), plain); err == nil {
+		t.Fatal("принят ключ без заверения")
+	}
+}
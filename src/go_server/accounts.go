@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrAccountExists  = errors.New("имя уже зарегистрировано")
+	ErrBadCredentials = errors.New("неверное имя или пароль")
+	ErrBadPassword    = errors.New("пароль должен содержать от 8 до 72 байт")
+)
+
+// accountKey приводит имя учётной записи к виду, под которым она хранится
+// и сравнивается: имена в чате различаются без учёта регистра, поэтому
+// и учётные записи «Alice» и «alice» — одна и та же
+func accountKey(name string) string {
+	return strings.ToLower(name)
+}
+
+func accountExists(name string) bool {
+	_, ok := store.Account(accountKey(name))
+	return ok
+}
+
+// registerAccount создаёт учётную запись; в хранилище попадает только
+// bcrypt-хеш пароля
+func registerAccount(name, password string) error {
+	if err := validName(name); err != nil {
+		return err
+	}
+	if len(password) < 8 || len(password) > 72 {
+		return ErrBadPassword
+	}
+	if accountExists(name) {
+		return ErrAccountExists
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return store.CreateAccount(accountKey(name), account{Hash: string(hash), Created: time.Now().UTC()})
+}
+
+// verifyAccount проверяет пароль; для неизвестного имени ошибка та же,
+// что и для неверного пароля
+func verifyAccount(name, password string) error {
+	acc, ok := store.Account(accountKey(name))
+	if !ok {
+		return ErrBadCredentials
+	}
+	if bcrypt.CompareHashAndPassword([]byte(acc.Hash), []byte(password)) != nil {
+		return ErrBadCredentials
+	}
+	return nil
+}
+
+// normalizeAccounts переводит учётные записи, сохранённые под именами
+// в разном регистре, к accountKey. Из записей, совпавших без учёта
+// регистра, остаётся самая ранняя.
+func normalizeAccounts(accounts map[string]account) map[string]account {
+	normalized := make(map[string]account, len(accounts))
+	for name, acc := range accounts {
+		key := accountKey(name)
+		if prev, ok := normalized[key]; ok {
+			log.Printf("⚠️ Учётные записи, различающиеся регистром, объединены в %s", key)
+			if !acc.Created.Before(prev.Created) {
+				continue
+			}
+		}
+		normalized[key] = acc
+	}
+	return normalized
+}
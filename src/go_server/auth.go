@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"airchat/protocol"
+)
+
+const authHint = "/login <имя> <пароль> или /register <имя> <пароль>"
+
+// authorized сообщает, может ли клиент писать в чат и подключаться к голосу
+func authorized(client *Client) bool {
+	return !*requireAuth || client.account != ""
+}
+
+// isModerator сообщает, вошёл ли клиент в учётную запись модератора
+// из -moderators. Имя гостя не даёт прав: его может занять кто угодно.
+func isModerator(client *Client) bool {
+	if client.account == "" {
+		return false
+	}
+	for _, name := range strings.Split(*moderators, ",") {
+		if accountKey(strings.TrimSpace(name)) == client.account {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBan возвращает ошибку, если имя заблокировано
+func checkBan(name string) error {
+	b, banned := store.Banned(name)
+	switch {
+	case !banned:
+		return nil
+	case b.Until.IsZero():
+		return fmt.Errorf("имя %s заблокировано: %s", name, b.Reason)
+	}
+	return fmt.Errorf("имя %s заблокировано до %s: %s", name, b.Until.Local().Format("02.01.2006 15:04"), b.Reason)
+}
+
+// handleAuth обрабатывает REGISTER и LOGIN. Вход возможен как до JOIN
+// (например, после отказа из-за зарегистрированного имени), так и после:
+// тогда клиент получает имя учётной записи.
+func handleAuth(p peer, msg protocol.Message) {
+	clientKey := p.Addr().String()
+	name, password := strings.TrimSpace(msg.Field(0)), msg.Field(1)
+	if err := validName(name); err != nil {
+		reject(p, err.Error())
+		return
+	}
+	if err := checkBan(name); err != nil {
+		reject(p, err.Error())
+		return
+	}
+
+	if msg.Type == protocol.TypeRegister {
+		clientsMux.RLock()
+		taken := nameTaken(name, clients[clientKey])
+		clientsMux.RUnlock()
+		if taken {
+			reject(p, "имя "+name+" уже занято")
+			return
+		}
+	}
+
+	// Хеширование медленное, поэтому выполняется без clientsMux
+	var err error
+	if msg.Type == protocol.TypeRegister {
+		err = registerAccount(name, password)
+	} else {
+		err = verifyAccount(name, password)
+	}
+	if err != nil {
+		reject(p, err.Error())
+		return
+	}
+
+	clientsMux.Lock()
+	defer clientsMux.Unlock()
+
+	client, joined := clients[clientKey]
+	if !joined {
+		if client = handshakes[clientKey]; client == nil {
+			reject(p, "сначала необходимо выполнить рукопожатие (HELLO)")
+			return
+		}
+	}
+	for _, other := range clients {
+		if other != client && other.account == accountKey(name) {
+			reject(p, "учётная запись "+name+" уже используется")
+			return
+		}
+	}
+
+	if msg.Type == protocol.TypeRegister {
+		log.Printf("🔑 Зарегистрирована учётная запись %s (%s)", name, clientKey)
+	} else {
+		log.Printf("🔑 %s вошёл как %s", clientKey, name)
+	}
+	client.account = accountKey(name)
+	deliver(client, protocol.New(protocol.TypeAuthOK, name))
+
+	switch {
+	case !joined && client.username != "":
+		// JOIN был отклонён из-за зарегистрированного имени — завершаем вход
+		joinClient(clientKey, client, name)
+	case joined && client.username != name:
+		renameClient(client, name)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"airchat/protocol"
+)
+
+// defaultChannel — канал, в который участник попадает при входе в чат.
+// Сообщения без указания канала тоже относятся к нему.
+const defaultChannel = "#general"
+
+const maxChannelLength = 32
+
+// channel — текстовый канал и его участники
+type channel struct {
+	name    string
+	members map[*Client]bool
+}
+
+// channels — участники текстовых каналов по имени; запись существует,
+// пока в канале кто-то есть. Сами каналы хранятся в store. Защищён clientsMux.
+var channels = make(map[string]*channel)
+
+// channelName приводит имя канала к каноническому виду: с '#'
+// и в нижнем регистре, чтобы #Dev и #dev были одним каналом
+func channelName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name != "" && !strings.HasPrefix(name, "#") {
+		name = "#" + name
+	}
+	return name
+}
+
+// validChannel проверяет каноническое имя канала
+func validChannel(name string) error {
+	if len(name) < 2 {
+		return errors.New("имя канала не может быть пустым")
+	}
+	if len([]rune(name)) > maxChannelLength {
+		return fmt.Errorf("имя канала длиннее %d символов", maxChannelLength)
+	}
+	if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return errors.New("имя канала не может содержать пробелы")
+	}
+	return nil
+}
+
+// inChannel сообщает, состоит ли клиент в канале.
+// Вызывающий должен держать clientsMux.
+func inChannel(client *Client, name string) bool {
+	ch, ok := channels[name]
+	return ok && ch.members[client]
+}
+
+// memberChannel возвращает канал сообщения, проверяя, что отправитель
+// в нём состоит. Пустое имя означает канал по умолчанию.
+// Вызывающий должен держать clientsMux.
+func memberChannel(client *Client, name string) (string, error) {
+	name = channelName(name)
+	if name == "" {
+		name = defaultChannel
+	}
+	if !inChannel(client, name) {
+		return "", errors.New("вы не состоите в канале " + name + ", войдите: /join " + name)
+	}
+	return name, nil
+}
+
+// channelBroadcast рассылает сообщение участникам канала, кроме except.
+// Вызывающий должен держать clientsMux.
+func channelBroadcast(name string, msg protocol.Message, except *Client) {
+	ch, ok := channels[name]
+	if !ok {
+		return
+	}
+	for member := range ch.members {
+		if member != except {
+			deliver(member, msg)
+		}
+	}
+}
+
+// memberNames возвращает отсортированные имена участников канала
+func (ch *channel) memberNames() []string {
+	names := make([]string, 0, len(ch.members))
+	for member := range ch.members {
+		names = append(names, member.username)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// joinChannel добавляет клиента в канал, создавая его при необходимости.
+// Клиент получает подтверждение со списком участников и последние
+// сообщения канала, остальные — уведомление. Вызывающий должен держать clientsMux.
+func joinChannel(client *Client, name string) {
+	ch, ok := channels[name]
+	if !ok {
+		ch = &channel{name: name, members: make(map[*Client]bool)}
+		channels[name] = ch
+	}
+	if _, known := store.Channel(name); !known {
+		info := channelInfo{Name: name, Creator: client.username, Created: time.Now().UTC()}
+		if err := store.SaveChannel(info); err != nil {
+			log.Printf("❌ Ошибка сохранения канала %s: %v", name, err)
+		} else {
+			log.Printf("📂 Создан канал %s", name)
+		}
+	}
+	joined := !ch.members[client]
+	if joined {
+		ch.members[client] = true
+		log.Printf("➡️ %s вошёл в %s", client.username, name)
+		channelBroadcast(name, protocol.New(protocol.TypeNotice,
+			client.username+" присоединился к "+name), client)
+	}
+	deliver(client, protocol.New(protocol.TypeChannelJoin, append([]string{name}, ch.memberNames()...)...))
+	if joined {
+		sendHistory(client, name, 0, *historyReplay)
+	}
+}
+
+// partChannel убирает клиента из канала и удаляет опустевший канал.
+// notify — сообщить ли об уходе клиенту и остальным участникам.
+// Вызывающий должен держать clientsMux.
+func partChannel(client *Client, name string, notify bool) {
+	ch, ok := channels[name]
+	if !ok || !ch.members[client] {
+		return
+	}
+	delete(ch.members, client)
+	if len(ch.members) == 0 {
+		delete(channels, name)
+	}
+	if notify {
+		log.Printf("⬅️ %s вышел из %s", client.username, name)
+		channelBroadcast(name, protocol.New(protocol.TypeNotice, client.username+" покинул "+name), client)
+		deliver(client, protocol.New(protocol.TypeChannelPart, name))
+	}
+}
+
+// partAllChannels убирает клиента из всех каналов без уведомлений:
+// об уходе из чата остальные узнают из общего уведомления.
+// Вызывающий должен держать clientsMux.
+func partAllChannels(client *Client) {
+	for name := range channels {
+		partChannel(client, name, false)
+	}
+}
+
+// handleChannelJoin обрабатывает вход в канал: [канал]
+func handleChannelJoin(p peer, msg protocol.Message) {
+	name := channelName(msg.Field(0))
+	if err := validChannel(name); err != nil {
+		reject(p, err.Error())
+		return
+	}
+
+	clientsMux.Lock()
+	defer clientsMux.Unlock()
+
+	client, ok := clients[p.Addr().String()]
+	if !ok {
+		return
+	}
+	if !authorized(client) {
+		reject(p, "сервер требует входа: "+authHint)
+		return
+	}
+	joinChannel(client, name)
+}
+
+// handleChannelPart обрабатывает выход из канала: [канал]
+func handleChannelPart(p peer, msg protocol.Message) {
+	name := channelName(msg.Field(0))
+
+	clientsMux.Lock()
+	defer clientsMux.Unlock()
+
+	client, ok := clients[p.Addr().String()]
+	if !ok {
+		return
+	}
+	if !inChannel(client, name) {
+		reject(p, "вы не состоите в канале "+name)
+		return
+	}
+	partChannel(client, name, true)
+}
+
+// handleChannels отвечает списком известных каналов с их участниками
+func handleChannels(p peer) {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	client, ok := clients[p.Addr().String()]
+	if !ok {
+		return
+	}
+	var fields []string
+	for _, info := range store.Channels() {
+		var members []string
+		if ch, ok := channels[info.Name]; ok {
+			members = ch.memberNames()
+		}
+		fields = append(fields, info.Name, strings.Join(members, " "))
+	}
+	deliver(client, protocol.New(protocol.TypeChannels, fields...))
+}
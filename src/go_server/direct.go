@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"airchat/protocol"
+)
+
+// findClient ищет участника чата по имени без учёта регистра.
+// Вызывающий должен держать clientsMux.
+func findClient(name string) (*Client, bool) {
+	for _, client := range clients {
+		if strings.EqualFold(client.username, name) {
+			return client, true
+		}
+	}
+	return nil, false
+}
+
+// handleDirect доставляет личное сообщение [получатель, текст, id ключа]
+// только получателю. Непустой id означает, что текст зашифрован ключом
+// отправителя, как в SEALED_CHAT; с -chat-e2e открытый текст не принимается.
+func handleDirect(p peer, msg protocol.Message) {
+	clientKey := p.Addr().String()
+	name, text, keyID := strings.TrimSpace(msg.Field(0)), msg.Field(1), msg.Field(2)
+	if *chatE2E && keyID == "" {
+		reject(p, "сервер принимает только зашифрованные сообщения")
+		return
+	}
+
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	sender, ok := clients[clientKey]
+	if !ok {
+		log.Printf("❌ Сообщение от неизвестного: %s", clientKey)
+		return
+	}
+	if !authorized(sender) {
+		reject(p, "сервер требует входа: "+authHint)
+		return
+	}
+	recipient, ok := findClient(name)
+	switch {
+	case ok && recipient == sender:
+		reject(p, "нельзя отправить личное сообщение себе")
+		return
+	case !ok && accountExists(name):
+		reject(p, "пользователь "+name+" не в сети")
+		return
+	case !ok:
+		reject(p, "пользователь "+name+" не найден")
+		return
+	}
+
+	if keyID != "" {
+		log.Printf("🔒 Личное сообщение %s -> %s (%d байт)", sender.username, recipient.username, len(text))
+	} else {
+		log.Printf("✉️ Личное сообщение %s -> %s", sender.username, recipient.username)
+	}
+	deliver(recipient, protocol.New(protocol.TypeDirect, sender.username, text, keyID))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"airchat/protocol"
+)
+
+// checkIdentity проверяет ключ идентичности из JOIN. Без сквозного
+// шифрования чата ключ необязателен: он нужен только для /verify.
+func checkIdentity(client *Client, identity []byte) error {
+	if len(identity) == 0 && !*chatE2E {
+		return nil
+	}
+	if *chatE2E && !contains(client.features, protocol.FeatureChatE2E) {
+		return errors.New("сервер требует сквозного шифрования чата")
+	}
+	if _, err := protocol.ParseIdentityKey(identity); err != nil {
+		return errors.New("некорректный ключ идентичности")
+	}
+	return nil
+}
+
+func identityMessage(client *Client) protocol.Message {
+	return protocol.New(protocol.TypeIdentity, client.username, string(client.identity))
+}
+
+// introduceIdentity знакомит нового клиента с ключами остальных
+// участников, а их — с его ключом. Вызывающий должен держать clientsMux.
+func introduceIdentity(client *Client) {
+	if len(client.identity) == 0 {
+		return
+	}
+	for _, other := range clients {
+		if other == client || len(other.identity) == 0 {
+			continue
+		}
+		deliver(other, identityMessage(client))
+		deliver(client, identityMessage(other))
+	}
+}
+
+// handleGroupKey пересылает ключ отправителя одного участника другому:
+// [имя получателя, id, ключ] -> [имя отправителя, id, ключ]
+func handleGroupKey(clientKey string, msg protocol.Message) {
+	if !*chatE2E {
+		return
+	}
+
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	sender, ok := clients[clientKey]
+	if !ok {
+		return
+	}
+	for _, recipient := range clients {
+		if recipient != sender && recipient.username == msg.Field(0) {
+			deliver(recipient, protocol.New(protocol.TypeGroupKey,
+				sender.username, msg.Field(1), msg.Field(2)))
+			return
+		}
+	}
+}
+
+// handleSealedChat рассылает зашифрованное сообщение участникам канала,
+// кроме отправителя. Содержимое серверу недоступно, в журнал попадает только размер.
+func handleSealedChat(p peer, msg protocol.Message) {
+	clientKey := p.Addr().String()
+	if !*chatE2E {
+		reject(p, "сквозное шифрование чата не согласовано")
+		return
+	}
+
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	sender, ok := clients[clientKey]
+	if !ok {
+		log.Printf("❌ Сообщение от неизвестного: %s", clientKey)
+		return
+	}
+	if !authorized(sender) {
+		reject(p, "сервер требует входа: "+authHint)
+		return
+	}
+	channel, replyTo, err := replyChannel(sender, msg.Field(2), msg.Field(3))
+	if err != nil {
+		reject(p, err.Error())
+		return
+	}
+	// В историю попадает только шифротекст
+	entry, err := appendHistory(sender, channel, msg.Field(1), msg.Field(0), replyTo)
+	if err != nil {
+		log.Printf("❌ Ошибка записи истории: %v", err)
+		reject(p, "не удалось сохранить сообщение")
+		return
+	}
+	log.Printf("🔒 Зашифрованное сообщение от %s в %s (%d байт)", sender.username, channel, len(msg.Field(1)))
+	fields := []string{sender.username, msg.Field(0), msg.Field(1),
+		channel, protocol.FormatID(entry.ID), protocol.FormatTime(entry.Time)}
+	channelBroadcast(channel, protocol.New(protocol.TypeSealedChat, append(fields, entry.Parent.Fields()...)...), nil)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+
+	"airchat/protocol"
+)
+
+// editMu упорядочивает изменения сохранённых сообщений (правку, удаление,
+// реакции): каждое читает сообщение, меняет его и записывает обратно
+var editMu sync.Mutex
+
+// editor — кто меняет сообщение. Поля клиента копируются под clientsMux,
+// чтобы запись в хранилище шла уже без него.
+type editor struct {
+	name      string
+	account   string
+	session   string
+	moderator bool
+}
+
+// editorOf находит клиента и проверяет, что он может менять сообщения
+func editorOf(p peer) (editor, bool) {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	client, ok := clients[p.Addr().String()]
+	if !ok {
+		return editor{}, false
+	}
+	if !authorized(client) {
+		reject(p, "сервер требует входа: "+authHint)
+		return editor{}, false
+	}
+	return editor{
+		name:      client.username,
+		account:   client.account,
+		session:   client.session,
+		moderator: isModerator(client),
+	}, true
+}
+
+// isAuthor сообщает, отправил ли сообщение этот пользователь. Сообщения
+// из учётной записи принадлежат ей; сообщения гостя — его сессии: имя
+// гостя после выхода может занять кто угодно.
+func (e editor) isAuthor(r historyRecord) bool {
+	if r.Account != "" {
+		return e.account == accountKey(r.Account)
+	}
+	return r.Session != "" && e.session == r.Session
+}
+
+// editableMessage находит сообщение по идентификатору из команды
+// и проверяет, что его можно изменить: это автор или модератор.
+// Вызывающий должен держать editMu.
+func (e editor) editableMessage(id string) (historyRecord, error) {
+	v, err := protocol.ParseID(id)
+	if err != nil {
+		return historyRecord{}, errors.New("некорректный идентификатор сообщения")
+	}
+	r, ok := store.Message(v)
+	if !ok || r.Deleted {
+		return historyRecord{}, errors.New("сообщение " + id + " не найдено")
+	}
+	if !e.isAuthor(r) && !e.moderator {
+		return historyRecord{}, errors.New("изменять сообщение может только его автор или модератор")
+	}
+	return r, nil
+}
+
+// handleEdit заменяет текст сообщения: [id, текст, id ключа]
+func handleEdit(p peer, msg protocol.Message) {
+	text, keyID := msg.Field(1), msg.Field(2)
+	switch {
+	case *chatE2E && keyID == "":
+		reject(p, "сервер принимает только зашифрованные сообщения")
+		return
+	case !*chatE2E && keyID != "":
+		reject(p, "сквозное шифрование чата не согласовано")
+		return
+	case strings.TrimSpace(text) == "":
+		reject(p, "новый текст не может быть пустым, для удаления: /delete")
+		return
+	}
+	e, ok := editorOf(p)
+	if !ok {
+		return
+	}
+
+	editMu.Lock()
+	r, err := e.editableMessage(msg.Field(0))
+	if err == nil && keyID != "" && !e.isAuthor(r) {
+		// Шифротекст получатели проверяют по ключу автора, поэтому
+		// зашифрованное сообщение модератор может только удалить
+		err = errors.New("зашифрованное сообщение может изменить только автор")
+	}
+	if err == nil {
+		r.Text, r.KeyID, r.Edited = text, keyID, true
+		if err = store.UpdateMessage(r); err != nil {
+			log.Printf("❌ Ошибка записи истории: %v", err)
+			err = errors.New("не удалось изменить сообщение")
+		} else {
+			index.add(r)
+		}
+	}
+	editMu.Unlock()
+	if err != nil {
+		reject(p, err.Error())
+		return
+	}
+
+	log.Printf("✏️ %s изменил сообщение %d в %s", e.name, r.ID, r.Channel)
+	clientsMux.RLock()
+	channelBroadcast(r.Channel, protocol.New(protocol.TypeEdit, r.Channel, protocol.FormatID(r.ID),
+		e.name, text, keyID), nil)
+	clientsMux.RUnlock()
+}
+
+// handleDelete стирает текст сообщения, оставляя отметку об удалении: [id]
+func handleDelete(p peer, msg protocol.Message) {
+	e, ok := editorOf(p)
+	if !ok {
+		return
+	}
+
+	editMu.Lock()
+	r, err := e.editableMessage(msg.Field(0))
+	if err == nil {
+		r.Text, r.KeyID, r.Deleted, r.Reactions = "", "", true, nil
+		if err = store.UpdateMessage(r); err != nil {
+			log.Printf("❌ Ошибка записи истории: %v", err)
+			err = errors.New("не удалось удалить сообщение")
+		}
+	}
+	editMu.Unlock()
+	if err != nil {
+		reject(p, err.Error())
+		return
+	}
+
+	log.Printf("🗑️ %s удалил сообщение %d в %s", e.name, r.ID, r.Channel)
+	clientsMux.RLock()
+	channelBroadcast(r.Channel, protocol.New(protocol.TypeDelete, r.Channel, protocol.FormatID(r.ID),
+		e.name), nil)
+	clientsMux.RUnlock()
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"airchat/protocol"
+)
+
+const (
+	// transferTTL — сколько предложение файла остаётся действительным
+	transferTTL = time.Hour
+	// sealOverhead — запас на шифрование части файла (E2E)
+	sealOverhead = 64
+)
+
+// transfer — предложенный файл и кто согласился его принять. Участники
+// указаны через principal, а не по именам: имя после выхода может занять
+// кто угодно. Передача переживает переподключение с возобновлением сессии
+// или повторным входом в учётную запись.
+type transfer struct {
+	offer     protocol.FileOffer
+	created   time.Time
+	sender    string          // principal отправителя
+	recipient string          // principal получателя личного файла
+	receivers map[string]bool // principal тех, кто запросил части
+}
+
+// principal — кто участвует в передаче: учётная запись, а у гостя — сессия
+func principal(client *Client) string {
+	if client.account != "" {
+		return "account:" + client.account
+	}
+	return "session:" + client.session
+}
+
+// findPrincipal ищет участника чата по principal.
+// Вызывающий должен держать clientsMux.
+func findPrincipal(id string) (*Client, bool) {
+	for _, client := range clients {
+		if principal(client) == id {
+			return client, true
+		}
+	}
+	return nil, false
+}
+
+var (
+	transfersMu sync.Mutex
+	transfers   = make(map[string]*transfer)
+)
+
+// findTransfer возвращает действующую передачу, удаляя просроченные
+func findTransfer(id string) (*transfer, bool) {
+	transfersMu.Lock()
+	defer transfersMu.Unlock()
+	for key, t := range transfers {
+		if time.Since(t.created) > transferTTL {
+			delete(transfers, key)
+		}
+	}
+	t, ok := transfers[id]
+	return t, ok
+}
+
+// filesAllowed проверяет, что клиент может передавать файлы.
+// Вызывающий должен держать clientsMux.
+func filesAllowed(client *Client) error {
+	switch {
+	case *maxFile <= 0:
+		return errors.New("сервер не принимает файлы")
+	case !contains(client.features, protocol.FeatureFiles):
+		return errors.New("передача файлов не согласована")
+	case !authorized(client):
+		return errors.New("сервер требует входа: " + authHint)
+	}
+	return nil
+}
+
+// handleFileOffer рассылает предложение файла получателю или участникам канала
+func handleFileOffer(p peer, msg protocol.Message) {
+	offer, err := protocol.ParseFileOffer(msg)
+	if err != nil || !protocol.ValidTransferID(offer.ID) || len(offer.Sum) != 64 {
+		reject(p, "некорректное предложение файла")
+		return
+	}
+	if offer.Name = filepath.Base(offer.Name); offer.Name == "." || offer.Name == "/" || offer.Name == ".." {
+		reject(p, "некорректное имя файла")
+		return
+	}
+	if offer.Size <= 0 || offer.Size > *maxFile {
+		reject(p, fmt.Sprintf("размер файла должен быть от 1 до %d байт", *maxFile))
+		return
+	}
+
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	sender, ok := clients[p.Addr().String()]
+	if !ok {
+		return
+	}
+	if err := filesAllowed(sender); err != nil {
+		reject(p, err.Error())
+		return
+	}
+
+	// Получатель — участник канала или пользователь в сети
+	var recipient *Client
+	if strings.HasPrefix(offer.Target, "#") {
+		if offer.Target, err = memberChannel(sender, offer.Target); err != nil {
+			reject(p, err.Error())
+			return
+		}
+	} else {
+		if recipient, ok = findClient(offer.Target); !ok {
+			reject(p, "пользователь "+offer.Target+" не в сети")
+			return
+		}
+		if recipient == sender {
+			reject(p, "нельзя отправить файл себе")
+			return
+		}
+		offer.Target = recipient.username
+	}
+	offer.Sender = sender.username
+
+	transfersMu.Lock()
+	if _, exists := transfers[offer.ID]; exists {
+		transfersMu.Unlock()
+		reject(p, "передача "+offer.ID+" уже существует")
+		return
+	}
+	t := &transfer{offer: offer, created: time.Now(), sender: principal(sender), receivers: make(map[string]bool)}
+	if recipient != nil {
+		t.recipient = principal(recipient)
+	}
+	transfers[offer.ID] = t
+	transfersMu.Unlock()
+
+	log.Printf("📎 %s предлагает файл %s (%d байт) для %s, передача %s",
+		sender.username, offer.Name, offer.Size, offer.Target, offer.ID)
+	if recipient != nil {
+		deliver(recipient, offer.Message())
+	} else {
+		channelBroadcast(offer.Target, offer.Message(), sender)
+	}
+}
+
+// handleFileAccept передаёт отправителю запрос частей файла
+// [id, смещение] от получателя
+func handleFileAccept(p peer, msg protocol.Message) {
+	id := msg.Field(0)
+	offset, err := strconv.ParseInt(msg.Field(1), 10, 64)
+	if err != nil || offset < 0 {
+		reject(p, "некорректное смещение")
+		return
+	}
+
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	client, ok := clients[p.Addr().String()]
+	if !ok {
+		return
+	}
+	if err := filesAllowed(client); err != nil {
+		reject(p, err.Error())
+		return
+	}
+	t, ok := findTransfer(id)
+	if !ok {
+		reject(p, "передача "+id+" не найдена или устарела")
+		return
+	}
+	offer := t.offer
+	if strings.HasPrefix(offer.Target, "#") && !inChannel(client, offer.Target) ||
+		!strings.HasPrefix(offer.Target, "#") && principal(client) != t.recipient {
+		reject(p, "файл предложен не вам")
+		return
+	}
+	if offset > offer.Size {
+		reject(p, "некорректное смещение")
+		return
+	}
+	sender, ok := findPrincipal(t.sender)
+	if !ok {
+		reject(p, "отправитель "+offer.Sender+" не в сети, повторите /accept позже")
+		return
+	}
+
+	transfersMu.Lock()
+	t.receivers[principal(client)] = true
+	transfersMu.Unlock()
+
+	if offset == offer.Size {
+		log.Printf("📎 %s получил файл %s от %s", client.username, offer.Name, offer.Sender)
+	}
+	deliver(sender, protocol.New(protocol.TypeFileAccept, id, client.username, msg.Field(1)))
+}
+
+// handleFileChunk пересылает часть файла от отправителя получателю
+func handleFileChunk(p peer, msg protocol.Message) {
+	chunk, err := protocol.ParseFileChunk(msg)
+	if err != nil {
+		reject(p, "некорректная часть файла")
+		return
+	}
+	switch {
+	case *chatE2E && chunk.KeyID == "":
+		reject(p, "сервер принимает только зашифрованные данные")
+		return
+	case !*chatE2E && chunk.KeyID != "":
+		reject(p, "сквозное шифрование чата не согласовано")
+		return
+	}
+
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	sender, ok := clients[p.Addr().String()]
+	if !ok {
+		return
+	}
+	t, ok := findTransfer(chunk.ID)
+	if !ok || t.sender != principal(sender) {
+		reject(p, "передача "+chunk.ID+" не найдена или устарела")
+		return
+	}
+
+	// Размер зашифрованной части сервер знает лишь приблизительно,
+	// открытой — точно
+	limit := int64(protocol.FileChunkSize)
+	end := chunk.Offset + int64(len(chunk.Data))
+	if chunk.KeyID != "" {
+		limit += sealOverhead
+		end = chunk.Offset + 1
+	}
+	if int64(len(chunk.Data)) > limit || end > t.offer.Size {
+		reject(p, "часть файла выходит за объявленный размер")
+		return
+	}
+
+	recipient, ok := findClient(chunk.Peer)
+	if !ok {
+		return
+	}
+	transfersMu.Lock()
+	accepted := t.receivers[principal(recipient)]
+	transfersMu.Unlock()
+	if !accepted {
+		return
+	}
+	chunk.Peer = sender.username
+	deliver(recipient, chunk.Message())
+}
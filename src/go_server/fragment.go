@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"airchat/protocol"
+)
+
+// fragmenter режет исходящие сообщения, не помещающиеся в одну датаграмму
+var fragmenter *protocol.Fragmenter
+
+// handleFragment добавляет фрагмент к собираемому сообщению клиента
+// и обрабатывает сообщение, когда оно собрано целиком
+func handleFragment(p peer, msg protocol.Message) {
+	clientKey := p.Addr().String()
+
+	clientsMux.RLock()
+	client, ok := clients[clientKey]
+	if !ok {
+		client, ok = handshakes[clientKey]
+	}
+	clientsMux.RUnlock()
+	if !ok {
+		log.Printf("❌ Фрагмент от неизвестного: %s", clientKey)
+		return
+	}
+
+	inner, complete, err := client.frags.Add(msg)
+	if err != nil {
+		var tooLarge *protocol.MessageTooLargeError
+		if errors.As(err, &tooLarge) {
+			reject(p, fmt.Sprintf("сообщение превышает лимит %d байт", tooLarge.Limit))
+			return
+		}
+		log.Printf("❌ Ошибка сборки сообщения от %s: %v", clientKey, err)
+		return
+	}
+	if complete {
+		handleMessage(p, inner)
+	}
+}
+
+// checkSize отклоняет целое, не фрагментированное сообщение больше
+// -max-message; фрагменты проверяет Reassembler при сборке. Без этой
+// проверки одна датаграмма или кадр TLS могли бы нести до MaxFrame байт.
+func checkSize(p peer, msg protocol.Message) bool {
+	if msg.Type == protocol.TypeFragment {
+		return true
+	}
+	if size := msg.Size(); size > *maxMessage {
+		reject(p, fmt.Sprintf("сообщение превышает лимит %d байт", *maxMessage))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"airchat/protocol"
+)
+
+// recordPeer запоминает отправленные ему кадры
+type recordPeer struct {
+	port   int
+	frames []protocol.Message
+}
+
+func (p *recordPeer) Addr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1 + p.port}
+}
+func (p *recordPeer) Stream() bool { return false }
+func (p *recordPeer) Close() error { return nil }
+
+func (p *recordPeer) WriteFrame(data []byte) error {
+	m, err := protocol.Decode(data)
+	if err != nil {
+		return err
+	}
+	p.frames = append(p.frames, m)
+	return nil
+}
+
+func TestCheckSize(t *testing.T) {
+	saved := *maxMessage
+	*maxMessage = 1000
+	defer func() { *maxMessage = saved }()
+
+	tests := []struct {
+		name string
+		msg  protocol.Message
+		ok   bool
+	}{
+		{"короткое", protocol.New(protocol.TypeChat, "привет", "#general"), true},
+		{"ровно лимит", protocol.New(protocol.TypeChat, strings.Repeat("x", 1000-protocol.HeaderSize-2)), true},
+		{"сверх лимита", protocol.New(protocol.TypeChat, strings.Repeat("x", 1000-protocol.HeaderSize-1)), false},
+		{"огромный кадр", protocol.New(protocol.TypeChat, strings.Repeat("x", protocol.MaxPayload-2)), false},
+		// Фрагменты проверяются при сборке
+		{"фрагмент", protocol.New(protocol.TypeFragment, "1", "0", "2", strings.Repeat("x", 1100)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &recordPeer{}
+			if got := checkSize(p, tt.msg); got != tt.ok {
+				t.Fatalf("checkSize = %v, ожидалось %v", got, tt.ok)
+			}
+			if tt.ok {
+				if len(p.frames) != 0 {
+					t.Fatalf("лишний ответ: %v", p.frames)
+				}
+				return
+			}
+			if len(p.frames) != 1 || p.frames[0].Type != protocol.TypeReject ||
+				!strings.Contains(p.frames[0].Field(0), "1000 байт") {
+				t.Fatalf("ожидался REJECT с лимитом, получено %v", p.frames)
+			}
+		})
+	}
+}
@@ -1,3 +1,12 @@
 module airchat/server
 
 go 1.21
+
+require (
+	airchat/protocol v0.0.0
+	golang.org/x/crypto v0.33.0
+)
+
+require golang.org/x/sys v0.30.0 // indirect
+
+replace airchat/protocol => ../go_protocol
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"airchat/protocol"
+)
+
+// serverFeatures возвращает возможности, которые сервер готов включить
+// по запросу клиента
+func serverFeatures() []string {
+	features := []string{protocol.FeatureVoice}
+	if *voiceE2E {
+		features = append(features, protocol.FeatureVoiceE2E)
+	}
+	if *chatE2E {
+		features = append(features, protocol.FeatureChatE2E)
+	}
+	if *maxFile > 0 {
+		features = append(features, protocol.FeatureFiles)
+	}
+	return features
+}
+
+// serverCodecs — голосовые кодеки, которые сервер умеет пересылать
+var serverCodecs = []string{protocol.CodecOpus}
+
+// handshakes хранит клиентов, прошедших HELLO, но ещё не приславших JOIN.
+// Защищается clientsMux.
+var handshakes = make(map[string]*Client)
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Ошибка генерации идентификатора сессии: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// reject отправляет клиенту отказ с читаемой причиной
+func reject(p peer, reason string) {
+	log.Printf("⛔ Отказ %s: %s", p.Addr(), reason)
+	send(p, protocol.New(protocol.TypeReject, reason))
+}
+
+// handleHello проверяет версию и кодеки клиента и отвечает WELCOME или REJECT
+func handleHello(p peer, msg protocol.Message) {
+	hello, err := protocol.ParseHello(msg)
+	if err != nil {
+		reject(p, "некорректное приветствие")
+		return
+	}
+	if hello.Version != protocol.Version {
+		reject(p, fmt.Sprintf("несовместимая версия протокола: клиент %d, сервер %d",
+			hello.Version, protocol.Version))
+		return
+	}
+	if len(protocol.Negotiate(hello.Codecs, serverCodecs)) == 0 {
+		reject(p, fmt.Sprintf("нет общих кодеков: клиент %v, сервер %v",
+			hello.Codecs, serverCodecs))
+		return
+	}
+
+	clientKey := p.Addr().String()
+
+	// Повторный HELLO (WELCOME потерялся) получает ту же сессию
+	clientsMux.Lock()
+	resumed, backlog := resumeSession(p, hello.Resume)
+	client, ok := handshakes[clientKey]
+	if resumed != nil {
+		client = resumed
+	} else if !ok {
+		client = &Client{
+			addr:     p.Addr(),
+			peer:     p,
+			session:  newSessionID(),
+			features: protocol.Negotiate(hello.Features, serverFeatures()),
+			out:      protocol.NewSender(),
+			in:       protocol.NewReceiver(),
+			frags:    protocol.NewReassembler(*maxMessage),
+		}
+		client.lastSeen.Store(time.Now().UnixNano())
+		handshakes[clientKey] = client
+	}
+	clientsMux.Unlock()
+
+	log.Printf("🤝 Рукопожатие с %s: сессия %s, возможности %v", clientKey, client.session, client.features)
+	send(p, protocol.Welcome{
+		Version:    protocol.Version,
+		Session:    client.session,
+		Features:   client.features,
+		MaxMessage: *maxMessage,
+		Heartbeat:  heartbeatInterval(),
+		Resumed:    resumed != nil,
+	}.Message())
+
+	if resumed != nil {
+		// Сообщения, отправленные на старый адрес, потеряны:
+		// заново знакомим клиента с ключами участников
+		clientsMux.Lock()
+		introduceIdentity(resumed)
+		clientsMux.Unlock()
+	}
+	for _, m := range backlog {
+		if checkSize(p, m) {
+			handleMessage(p, m)
+		}
+	}
+}
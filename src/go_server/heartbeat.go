@@ -0,0 +1,57 @@
+package main
+
+import (
+	"time"
+)
+
+// heartbeatInterval — как часто клиенту следует присылать PING:
+// за время -timeout должно успеть прийти несколько
+func heartbeatInterval() time.Duration {
+	return *clientTimeout / 3
+}
+
+// touch отмечает, что от клиента пришло сообщение, и сообщает,
+// есть ли у него сессия
+func touch(clientKey string) bool {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	client, ok := clients[clientKey]
+	if !ok {
+		client, ok = handshakes[clientKey]
+	}
+	if ok {
+		client.lastSeen.Store(time.Now().UnixNano())
+	}
+	return ok
+}
+
+// evictLoop отключает клиентов, от которых дольше -timeout не было
+// ни одного сообщения, включая не завершивших рукопожатие
+func evictLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		deadline := now.Add(-*clientTimeout).UnixNano()
+
+		var stale []*Client
+		clientsMux.RLock()
+		for _, client := range clients {
+			if client.lastSeen.Load() < deadline {
+				stale = append(stale, client)
+			}
+		}
+		for _, client := range handshakes {
+			if client.lastSeen.Load() < deadline {
+				stale = append(stale, client)
+			}
+		}
+		clientsMux.RUnlock()
+
+		for _, client := range stale {
+			removeClient(client.addr.String(), "превышено время ожидания")
+			client.peer.Close()
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"cmp"
+	"log"
+	"slices"
+	"strconv"
+
+	"airchat/protocol"
+)
+
+// maxHistoryPage — сколько сообщений истории можно запросить за раз
+const maxHistoryPage = 100
+
+func (r historyRecord) entry() protocol.Entry {
+	return protocol.Entry{
+		Channel:   r.Channel,
+		ID:        r.ID,
+		Time:      r.Time,
+		Sender:    r.Sender,
+		Text:      r.Text,
+		KeyID:     r.KeyID,
+		Edited:    r.Edited,
+		Reactions: r.reactionCounts(),
+		Parent:    excerptOf(r.ReplyTo),
+	}
+}
+
+// indexHistory строит поисковый индекс и индекс ответов по истории,
+// загруженной из хранилища
+func indexHistory() {
+	var records []historyRecord
+	store.EachMessage(func(r historyRecord) {
+		records = append(records, r)
+	})
+	slices.SortFunc(records, func(a, b historyRecord) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	for _, r := range records {
+		index.add(r)
+		replies.add(r)
+	}
+	if len(records) > 0 {
+		log.Printf("🔎 Проиндексировано сообщений: %d", len(records))
+	}
+}
+
+// appendHistory сохраняет сообщение клиента в канале и индексирует его;
+// replyTo — сообщение, на которое это отвечает, или 0
+func appendHistory(client *Client, channel, text, keyID string, replyTo uint64) (protocol.Entry, error) {
+	r := historyRecord{
+		Channel: channel,
+		Sender:  client.username,
+		Account: client.account,
+		Text:    text,
+		KeyID:   keyID,
+		ReplyTo: replyTo,
+	}
+	if client.account == "" {
+		r.Session = client.session
+	}
+	r, err := store.AppendMessage(r)
+	if err != nil {
+		return protocol.Entry{}, err
+	}
+	index.add(r)
+	replies.add(r)
+	return r.entry(), nil
+}
+
+// sendHistory отправляет клиенту до n сообщений канала, предшествующих before
+func sendHistory(client *Client, channel string, before uint64, n int) {
+	records := store.MessagesBefore(channel, before, n)
+	if len(records) == 0 && before != 0 {
+		deliver(client, protocol.New(protocol.TypeNotice, "Более ранних сообщений в "+channel+" нет"))
+	}
+	for _, r := range records {
+		if !r.Deleted {
+			deliver(client, r.entry().Message())
+		}
+	}
+}
+
+// handleHistory отвечает на запрос истории: [канал, до id, количество]
+func handleHistory(p peer, msg protocol.Message) {
+	var before uint64
+	if msg.Field(1) != "" {
+		id, err := protocol.ParseID(msg.Field(1))
+		if err != nil {
+			reject(p, "некорректный идентификатор сообщения")
+			return
+		}
+		before = id
+	}
+	n := *historyReplay
+	if msg.Field(2) != "" {
+		v, err := strconv.Atoi(msg.Field(2))
+		if err != nil || v <= 0 {
+			reject(p, "количество сообщений должно быть положительным числом")
+			return
+		}
+		n = min(v, maxHistoryPage)
+	}
+
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	client, ok := clients[p.Addr().String()]
+	if !ok {
+		return
+	}
+	channel, err := memberChannel(client, msg.Field(0))
+	if err != nil {
+		reject(p, err.Error())
+		return
+	}
+	sendHistory(client, channel, before, n)
+}
@@ -1,21 +1,51 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+
+	"airchat/protocol"
 )
 
 type Client struct {
 	addr      net.Addr
+	peer      peer // управляющий канал: UDP или TLS
 	username  string
 	inVoice   bool
-	voiceAddr string // Добавляем адрес для голосового соединения
+	voiceChan string   // голосовой канал, в котором участник
+	voiceAddr string   // Добавляем адрес для голосового соединения
+	voiceSSRC uint32   // идентификатор отправителя в голосовых пакетах
+	voicePub  []byte   // открытый ключ X25519 из VOICE_CONNECT
+	voiceKey  []byte   // ключ голоса клиента (только в режиме VoiceModeServer)
+	session   string   // идентификатор сессии, выданный в WELCOME
+	features  []string // возможности, согласованные при рукопожатии
+	identity  []byte   // открытый ключ идентичности X25519 из JOIN
+	account   string   // учётная запись после LOGIN/REGISTER, пусто — гость
+	status    string   // состояние присутствия (protocol.StatusOnline...)
+	statusMsg string   // текст статуса, выбранный пользователем
+
+	statusSent    time.Time // когда последний раз разослан его статус
+	statusPending bool      // рассылка статуса отложена из-за statusThrottle
+
+	out   *protocol.Sender      // исходящие нумерованные сообщения
+	in    *protocol.Receiver    // входящие нумерованные сообщения
+	frags *protocol.Reassembler // сборка фрагментированных сообщений
+
+	lastSeen   atomic.Int64 // время последнего сообщения от клиента, UnixNano
+	lastTyping atomic.Int64 // когда последний раз разослан его TYPING, UnixNano
+	// resync — нумерованное сообщение клиенту потеряно: его приём стоит,
+	// пока он не продолжит сессию с новой нумерацией
+	resync atomic.Bool
 }
 
 var (
@@ -23,14 +53,129 @@ var (
 	clientsMux sync.RWMutex
 )
 
+var (
+	maxMessage = flag.Int("max-message", protocol.DefaultMaxMessage,
+		"максимальный размер сообщения в байтах")
+	tlsAddr = flag.String("tls", "",
+		"адрес TLS-листенера для чата и сигнализации (например :6443), пусто — выключен")
+	tlsCert  = flag.String("tls-cert", "", "файл сертификата TLS (PEM); без него создаётся самоподписанный")
+	tlsKey   = flag.String("tls-key", "", "файл закрытого ключа TLS (PEM)")
+	voiceE2E = flag.Bool("voice-e2e", false,
+		"сквозное шифрование голоса: сервер пересылает пакеты, не зная ключей")
+	chatE2E = flag.Bool("chat-e2e", false,
+		"сквозное шифрование текстового чата: сервер пересылает только шифротекст")
+	storageKind = flag.String("storage", "file",
+		"хранилище учётных записей, каналов, истории и блокировок: file или memory")
+	dataDir     = flag.String("data", ".", "каталог данных для -storage file")
+	requireAuth = flag.Bool("require-auth", false,
+		"разрешать чат и голос только после входа в учётную запись")
+	moderators = flag.String("moderators", "",
+		"учётные записи модераторов через запятую: могут править и удалять чужие сообщения")
+	clientTimeout = flag.Duration("timeout", 30*time.Second,
+		"через сколько отключать клиента, от которого нет сообщений")
+	historyReplay = flag.Int("history-replay", 20,
+		"сколько последних сообщений канала показывать при входе в него")
+	maxFile = flag.Int64("max-file", 10<<20,
+		"максимальный размер передаваемого файла в байтах, 0 — передача файлов выключена")
+)
+
+// send кодирует сообщение и отправляет его без гарантии доставки
+func send(p peer, msg protocol.Message) {
+	data, err := protocol.Encode(msg)
+	if err != nil {
+		log.Printf("❌ Ошибка кодирования %s: %v", msg.Type, err)
+		return
+	}
+	if err := p.WriteFrame(data); err != nil {
+		log.Printf("❌ Ошибка отправки %s на %s: %v", msg.Type, p.Addr(), err)
+	}
+}
+
+// broadcast рассылает сообщение всем клиентам, кроме except (если задан).
+// Вызывающий должен держать clientsMux.
+func broadcast(msg protocol.Message, except string) {
+	for key, client := range clients {
+		if key != except {
+			deliver(client, msg)
+		}
+	}
+}
+
+// joinClient переносит клиента из handshakes в чат под именем name
+// и уведомляет остальных. Занятое имя получает числовой суффикс;
+// если итоговое имя отличается от запрошенного в JOIN, клиент узнаёт
+// его из RENAME. Вызывающий должен держать clientsMux.
+func joinClient(clientKey string, client *Client, name string) {
+	requested := client.username
+	client.username = uniqueName(name, client)
+	delete(handshakes, clientKey)
+	// Голосовой адрес станет известен с первым проверенным пакетом:
+	// клиент отправляет голос с произвольного порта
+	client.voiceAddr = ""
+	clients[clientKey] = client
+	log.Printf("✨ Новый клиент: %s (%s)", client.username, strings.Split(clientKey, ":")[0])
+
+	// Уведомляем всех о новом пользователе
+	broadcast(protocol.New(protocol.TypeNotice, client.username+" joined the chat"), clientKey)
+	introduceIdentity(client)
+	introducePresence(client)
+	if client.username != requested {
+		deliver(client, protocol.New(protocol.TypeRename, requested, client.username))
+	}
+	joinChannel(client, defaultChannel)
+	if !authorized(client) {
+		deliver(client, protocol.New(protocol.TypeNotice, "Сервер требует входа: "+authHint))
+	}
+}
+
+// detachClient убирает клиента из голосового чата и из всех таблиц,
+// освобождая его имя. Возвращает nil, если клиент не входил в чат.
+// Вызывающий должен держать clientsMux.
+func detachClient(clientKey string) *Client {
+	delete(handshakes, clientKey)
+	client, ok := clients[clientKey]
+	if !ok {
+		return nil
+	}
+	leaveVoice(client)
+	partAllChannels(client)
+	delete(clients, clientKey)
+	return client
+}
+
+// removeClient удаляет клиента и, если он успел войти в чат,
+// уведомляет остальных с указанием причины
+func removeClient(clientKey, reason string) {
+	clientsMux.Lock()
+	defer clientsMux.Unlock()
+
+	client := detachClient(clientKey)
+	if client == nil {
+		return
+	}
+	log.Printf("👋 %s (%s) отключён: %s", client.username, clientKey, reason)
+	broadcast(protocol.New(protocol.TypeNotice, client.username+" отключился ("+reason+")"), "")
+}
+
+// handleLeave обрабатывает LEAVE: клиент вышел из чата сам
+func handleLeave(clientKey string) {
+	clientsMux.Lock()
+	defer clientsMux.Unlock()
+
+	client := detachClient(clientKey)
+	if client == nil {
+		return
+	}
+	log.Printf("👋 %s (%s) вышел из чата", client.username, clientKey)
+	broadcast(protocol.New(protocol.TypeNotice, client.username+" left the chat"), "")
+}
+
 func cleanup(pc, voiceConn net.PacketConn) {
 	log.Println("Завершение работы сервера...")
 
 	// Отправляем всем клиентам сообщение о завершении работы
 	clientsMux.RLock()
-	for _, client := range clients {
-		pc.WriteTo([]byte("Сервер завершает работу"), client.addr)
-	}
+	broadcast(protocol.New(protocol.TypeNotice, "Сервер завершает работу"), "")
 	clientsMux.RUnlock()
 
 	// Закрываем соединения
@@ -40,6 +185,9 @@ func cleanup(pc, voiceConn net.PacketConn) {
 	if voiceConn != nil {
 		voiceConn.Close()
 	}
+	if store != nil {
+		store.Close()
+	}
 }
 
 func handleVoiceData(voiceConn net.PacketConn) {
@@ -71,29 +219,18 @@ func handleVoiceData(voiceConn net.PacketConn) {
 			packetsProcessed = 0
 		}
 
-		// Ищем отправителя по voiceAddr, если не найден — по IP
+		// Ищем отправителя по ssrc из заголовка пакета
 		var sender *Client
 		var exists bool
 
+		ssrc, err := protocol.VoiceSSRC(buffer[:n])
 		clientsMux.Lock() // LOCK для возможного обновления!
-		for _, client := range clients {
-			if client.voiceAddr == remoteAddr.String() {
-				sender = client
-				exists = true
-				break
-			}
+		if err == nil {
+			sender, exists = voiceSender(ssrc)
 		}
-		// Если не найден по voiceAddr — ищем по IP и inVoice
-		if !exists {
-			for _, client := range clients {
-				if client.inVoice && strings.Split(client.addr.String(), ":")[0] == senderIP {
-					log.Printf("Обновление voiceAddr для %s: %s -> %s", client.username, client.voiceAddr, remoteAddr.String())
-					client.voiceAddr = remoteAddr.String()
-					sender = client
-					exists = true
-					break
-				}
-			}
+		if exists && !verifyVoicePacket(sender, buffer[:n], remoteAddr) {
+			clientsMux.Unlock()
+			continue
 		}
 
 		if !exists || !sender.inVoice {
@@ -107,18 +244,19 @@ func handleVoiceData(voiceConn net.PacketConn) {
 					if c.inVoice {
 						status = "🔊"
 					}
-					log.Printf("%s %s (%s) -> %s", status, c.username,
-						strings.Split(c.addr.String(), ":")[0], c.voiceAddr)
+					log.Printf("%s %s (%s) -> %s %s", status, c.username,
+						strings.Split(c.addr.String(), ":")[0], c.voiceAddr, c.voiceChan)
 				}
 				lastClientListTime = time.Now()
 			}
 			continue
 		}
 
-		// Отправляем голосовые данные всем клиентам в голосовом чате
+		// Отправляем голосовые данные участникам того же голосового канала
 		recipientCount := 0
 		for _, client := range clients {
-			if client.inVoice && client.voiceAddr != remoteAddr.String() {
+			if client.inVoice && client != sender && client.voiceAddr != "" &&
+				client.voiceChan == sender.voiceChan {
 				voiceAddr, err := net.ResolveUDPAddr("udp", client.voiceAddr)
 				if err != nil {
 					log.Printf("❌ Ошибка адреса %s: %v", client.username, err)
@@ -143,6 +281,26 @@ func handleVoiceData(voiceConn net.PacketConn) {
 }
 
 func main() {
+	flag.Parse()
+	if *maxMessage <= 0 || *maxMessage > protocol.LimitMaxMessage {
+		log.Fatalf("Недопустимый -max-message: %d (допустимо 1..%d)", *maxMessage, protocol.LimitMaxMessage)
+	}
+	if *clientTimeout < time.Second {
+		log.Fatalf("Недопустимый -timeout: %v (минимум 1s)", *clientTimeout)
+	}
+	if *historyReplay < 0 || *historyReplay > maxHistoryPage {
+		log.Fatalf("Недопустимый -history-replay: %d (допустимо 0..%d)", *historyReplay, maxHistoryPage)
+	}
+	fragmenter = protocol.NewFragmenter(*maxMessage)
+	if err := initVoiceCrypto(); err != nil {
+		log.Fatal("Ошибка инициализации шифрования голоса:", err)
+	}
+	var err error
+	if store, err = openStorage(*storageKind, *dataDir); err != nil {
+		log.Fatal("Ошибка открытия хранилища:", err)
+	}
+	indexHistory()
+
 	// Создаем канал для обработки сигналов завершения
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -164,9 +322,23 @@ func main() {
 	log.Println("Сервер запущен на порту :6000")
 	log.Println("Голосовой сервер запущен на порту :6001")
 
+	if *tlsAddr != "" {
+		cert, err := loadCertificate(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatal("Ошибка загрузки сертификата TLS:", err)
+		}
+		if err := serveTLS(*tlsAddr, cert); err != nil {
+			log.Fatal("Ошибка запуска TLS-сервера:", err)
+		}
+	}
+
 	// Запускаем обработку голосовых данных в отдельной горутине
 	go handleVoiceData(voiceConn)
 
+	// Повторная отправка неподтверждённых сообщений
+	go retransmitLoop()
+	go evictLoop()
+
 	// Горутина для обработки сигналов завершения
 	go func() {
 		<-sigChan
@@ -175,87 +347,272 @@ func main() {
 	}()
 
 	for {
-		buffer := make([]byte, 4096)
+		buffer := make([]byte, protocol.MaxFrame)
 		n, addr, err := pc.ReadFrom(buffer)
 		if err != nil {
 			log.Printf("Ошибка чтения: %v", err)
 			continue
 		}
+		p := udpPeer{pc: pc, addr: addr}
 
-		msg := string(buffer[:n])
-		clientKey := addr.String()
-
-		// Обработка нового подключения
-		if strings.Contains(msg, " joined the chat") {
-			username := strings.Split(msg, " joined the chat")[0]
-			clientIP := strings.Split(clientKey, ":")[0]
-
-			clientsMux.Lock()
-			clients[clientKey] = &Client{
-				addr:      addr,
-				username:  username,
-				inVoice:   false,
-				voiceAddr: clientIP + ":6001",
+		msg, err := protocol.Decode(buffer[:n])
+		if err != nil {
+			var verr *protocol.VersionError
+			if errors.As(err, &verr) {
+				reject(p, fmt.Sprintf("несовместимая версия протокола: клиент %d, сервер %d",
+					verr.Got, protocol.Version))
+				continue
 			}
-			clientsMux.Unlock()
-			log.Printf("✨ Новый клиент: %s (%s) -> %s", username, clientIP, clientIP+":6001")
-
-			// Уведомляем всех о новом пользователе
-			clientsMux.RLock()
-			for _, client := range clients {
-				if client.addr.String() != clientKey {
-					pc.WriteTo([]byte(msg), client.addr)
-				}
+			log.Printf("❌ Некорректный кадр от %s: %v", addr, err)
+			continue
+		}
+		if !msg.Reliable() {
+			if checkSize(p, msg) {
+				handleMessage(p, msg)
 			}
-			clientsMux.RUnlock()
 			continue
 		}
 
-		// Обработка голосовых уведомлений
-		if msg == "VOICE_CONNECT" {
-			clientsMux.Lock()
-			if client, ok := clients[clientKey]; ok {
-				client.inVoice = true
-				notification := client.username + " подключился к голосовому чату"
-				log.Printf("🎤 %s (%s) вошёл в голосовой чат",
-					client.username, strings.Split(clientKey, ":")[0])
-
-				// Уведомляем всех о подключении к голосовому чату
-				for _, c := range clients {
-					pc.WriteTo([]byte(notification), c.addr)
-				}
-			} else {
-				log.Printf("❌ Попытка подключения от неизвестного: %s", clientKey)
+		// Нумерованное сообщение: подтверждаем всегда (ACK мог потеряться),
+		// обрабатываем только новые и строго по порядку
+		send(p, protocol.AckFor(msg))
+		in := receiverFor(addr.String())
+		if in == nil {
+			log.Printf("❌ Нумерованное сообщение вне сессии от %s", addr)
+			continue
+		}
+		// Размер проверяется после Accept: отброшенный до него номер
+		// остановил бы приём всех следующих сообщений
+		for _, m := range in.Accept(msg) {
+			if checkSize(p, m) {
+				handleMessage(p, m)
 			}
+		}
+	}
+}
+
+// handleMessage обрабатывает одно управляющее сообщение от клиента
+func handleMessage(p peer, msg protocol.Message) {
+	clientKey := p.Addr().String()
+	known := touch(clientKey)
+
+	switch msg.Type {
+	case protocol.TypeHello:
+		handleHello(p, msg)
+
+	case protocol.TypeAck:
+		handleAck(clientKey, msg.Seq)
+
+	case protocol.TypePing:
+		// Клиент без сессии (например, отключённый по таймауту) ответа
+		// не получает и по тишине понимает, что нужно переподключиться
+		// Клиенту, потерявшему сообщение, вместо PONG повторяется RESYNC:
+		// первый мог потеряться так же
+		if known && needsResync(clientKey) {
+			send(p, protocol.New(protocol.TypeResync))
+		} else if known {
+			send(p, protocol.New(protocol.TypePong))
+		}
+
+	case protocol.TypeFragment:
+		handleFragment(p, msg)
+
+	case protocol.TypeVoiceKey:
+		handleVoiceKey(clientKey, msg)
+
+	case protocol.TypeGroupKey:
+		handleGroupKey(clientKey, msg)
+
+	case protocol.TypeSealedChat:
+		handleSealedChat(p, msg)
+
+	case protocol.TypeDirect:
+		handleDirect(p, msg)
+
+	case protocol.TypeRegister, protocol.TypeLogin:
+		handleAuth(p, msg)
+
+	case protocol.TypeNick:
+		handleNick(p, msg)
+
+	case protocol.TypeLeave:
+		handleLeave(clientKey)
+
+	case protocol.TypeChannelJoin:
+		handleChannelJoin(p, msg)
+
+	case protocol.TypeChannelPart:
+		handleChannelPart(p, msg)
+
+	case protocol.TypeChannels:
+		handleChannels(p)
+
+	case protocol.TypeHistory:
+		handleHistory(p, msg)
+
+	case protocol.TypeVoiceList:
+		handleVoiceList(p)
+
+	case protocol.TypeSearch:
+		handleSearch(p, msg)
+
+	case protocol.TypeEdit:
+		handleEdit(p, msg)
+
+	case protocol.TypeDelete:
+		handleDelete(p, msg)
+
+	case protocol.TypeReact:
+		handleReact(p, msg)
+
+	case protocol.TypeThread:
+		handleThread(p, msg)
+
+	case protocol.TypeFileOffer:
+		handleFileOffer(p, msg)
+
+	case protocol.TypeFileAccept:
+		handleFileAccept(p, msg)
+
+	case protocol.TypeFileChunk:
+		handleFileChunk(p, msg)
+
+	case protocol.TypeTyping:
+		handleTyping(p, msg)
+
+	case protocol.TypeStatus:
+		handleStatus(p, msg)
+
+	case protocol.TypeJoin:
+		// Обработка нового подключения
+		username := strings.TrimSpace(msg.Field(0))
+		if err := validName(username); err != nil {
+			reject(p, err.Error())
+			return
+		}
+		if err := checkBan(username); err != nil {
+			reject(p, err.Error())
+			return
+		}
+
+		clientsMux.Lock()
+		client, ok := handshakes[clientKey]
+		if !ok {
 			clientsMux.Unlock()
-			continue
+			reject(p, "сначала необходимо выполнить рукопожатие (HELLO)")
+			return
+		}
+		identity := []byte(msg.Field(1))
+		if err := checkIdentity(client, identity); err != nil {
+			clientsMux.Unlock()
+			reject(p, err.Error())
+			return
+		}
+		client.identity = identity
+		client.username = username
+		if client.account != "" && client.account != accountKey(username) {
+			username = client.account
+		} else if accountExists(username) {
+			// Зарегистрированное имя занимается только после входа;
+			// клиент остаётся в handshakes и может выполнить LOGIN
+			clientsMux.Unlock()
+			reject(p, fmt.Sprintf("имя %s зарегистрировано, войдите: /login %s <пароль>", username, username))
+			return
 		}
+		joinClient(clientKey, client, username)
+		clientsMux.Unlock()
 
-		if msg == "VOICE_DISCONNECT" {
-			clientsMux.Lock()
-			if client, ok := clients[clientKey]; ok {
-				client.inVoice = false
-				notification := client.username + " отключился от голосового чата"
-				log.Printf("🔇 %s (%s) вышел из голосового чата",
-					client.username, strings.Split(clientKey, ":")[0])
+	case protocol.TypeVoiceConnect:
+		channel := channelName(msg.Field(1))
+		if channel == "" {
+			channel = defaultChannel
+		}
+		if err := validChannel(channel); err != nil {
+			reject(p, err.Error())
+			return
+		}
 
-				// Уведомляем всех об отключении от голосового чата
-				for _, c := range clients {
-					pc.WriteTo([]byte(notification), c.addr)
-				}
+		clientsMux.Lock()
+		if client, ok := clients[clientKey]; ok {
+			if !authorized(client) {
+				clientsMux.Unlock()
+				reject(p, "сервер требует входа: "+authHint)
+				return
 			}
-			clientsMux.Unlock()
-			continue
+			if err := joinVoice(client, channel, []byte(msg.Field(0))); err != nil {
+				clientsMux.Unlock()
+				reject(p, err.Error())
+				return
+			}
+			notification := client.username + " подключился к голосовому каналу " + channel
+			log.Printf("🎤 %s (%s) вошёл в голосовой канал %s",
+				client.username, strings.Split(clientKey, ":")[0], channel)
+
+			// Уведомляем всех о подключении к голосовому чату
+			broadcast(protocol.New(protocol.TypeNotice, notification), "")
+		} else {
+			log.Printf("❌ Попытка подключения от неизвестного: %s", clientKey)
+		}
+		clientsMux.Unlock()
+
+	case protocol.TypeVoiceDisconnect:
+		clientsMux.Lock()
+		if client, ok := clients[clientKey]; ok && client.inVoice {
+			channel := client.voiceChan
+			leaveVoice(client)
+			notification := client.username + " отключился от голосового канала " + channel
+			log.Printf("🔇 %s (%s) вышел из голосового канала %s",
+				client.username, strings.Split(clientKey, ":")[0], channel)
+
+			// Уведомляем всех об отключении от голосового чата
+			broadcast(protocol.New(protocol.TypeNotice, notification), "")
 		}
+		clientsMux.Unlock()
 
-		// Рассылаем обычные сообщения всем клиентам
-		log.Printf("Сообщение от %s: %s", clientKey, msg)
+	case protocol.TypeChat:
 		clientsMux.RLock()
-		for _, client := range clients {
-			if client.addr.String() != clientKey {
-				pc.WriteTo([]byte(msg), client.addr)
-			}
+		client, ok := clients[clientKey]
+		if !ok {
+			clientsMux.RUnlock()
+			log.Printf("❌ Сообщение от неизвестного: %s", clientKey)
+			return
+		}
+		if !authorized(client) {
+			clientsMux.RUnlock()
+			reject(p, "сервер требует входа: "+authHint)
+			return
+		}
+		if *chatE2E {
+			clientsMux.RUnlock()
+			reject(p, "сервер принимает только зашифрованные сообщения")
+			return
+		}
+
+		channel, replyTo, err := replyChannel(client, msg.Field(1), msg.Field(2))
+		if err != nil {
+			clientsMux.RUnlock()
+			reject(p, err.Error())
+			return
+		}
+
+		entry, err := appendHistory(client, channel, msg.Field(0), "", replyTo)
+		if err != nil {
+			clientsMux.RUnlock()
+			log.Printf("❌ Ошибка записи истории: %v", err)
+			reject(p, "не удалось сохранить сообщение")
+			return
 		}
+
+		// Рассылаем сообщение участникам канала; отправителя указывает
+		// сервер, а не текст сообщения. Отправитель тоже получает копию,
+		// чтобы узнать идентификатор сообщения для /edit и /delete
+		log.Printf("Сообщение от %s (%s) в %s: %s", client.username, clientKey, channel, msg.Field(0))
+		fields := []string{client.username, msg.Field(0), channel,
+			protocol.FormatID(entry.ID), protocol.FormatTime(entry.Time)}
+		channelBroadcast(channel, protocol.New(protocol.TypeChat, append(fields, entry.Parent.Fields()...)...), nil)
 		clientsMux.RUnlock()
+
+	default:
+		log.Printf("❌ Неизвестный тип сообщения %s от %s", msg.Type, clientKey)
 	}
 }
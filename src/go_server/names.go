@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"airchat/protocol"
+)
+
+const maxNameLength = 32
+
+// validName проверяет имя пользователя: непустое, без пробелов и не длиннее maxNameLength
+func validName(name string) error {
+	if name == "" {
+		return errors.New("имя не может быть пустым")
+	}
+	if len([]rune(name)) > maxNameLength {
+		return fmt.Errorf("имя длиннее %d символов", maxNameLength)
+	}
+	if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return errors.New("имя не может содержать пробелы")
+	}
+	return nil
+}
+
+// nameTaken сообщает, занято ли имя другим участником чата.
+// Имена сравниваются без учёта регистра, чтобы нельзя было выдать
+// себя за другого. Вызывающий должен держать clientsMux.
+func nameTaken(name string, except *Client) bool {
+	for _, client := range clients {
+		if client != except && strings.EqualFold(client.username, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// nameAvailable сообщает, может ли client взять имя: оно свободно
+// и не принадлежит чужой учётной записи. Вызывающий должен держать clientsMux.
+func nameAvailable(name string, client *Client) bool {
+	if nameTaken(name, client) {
+		return false
+	}
+	return client.account == accountKey(name) || !accountExists(name)
+}
+
+// uniqueName возвращает name или, если оно занято, name с числовым
+// суффиксом. Вызывающий должен держать clientsMux.
+func uniqueName(name string, client *Client) string {
+	candidate := name
+	for i := 2; !nameAvailable(candidate, client); i++ {
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+	return candidate
+}
+
+// renameClient меняет имя участника и сообщает об этом всем, включая
+// его самого. Вызывающий должен держать clientsMux.
+func renameClient(client *Client, name string) {
+	old := client.username
+	client.username = name
+	log.Printf("✏️ %s теперь %s", old, name)
+	broadcast(protocol.New(protocol.TypeRename, old, name), "")
+	// Ключ идентичности известен остальным под старым именем
+	introduceIdentity(client)
+}
+
+// handleNick обрабатывает смену имени: [новое имя]
+func handleNick(p peer, msg protocol.Message) {
+	clientKey := p.Addr().String()
+	name := strings.TrimSpace(msg.Field(0))
+	if err := validName(name); err != nil {
+		reject(p, err.Error())
+		return
+	}
+	if err := checkBan(name); err != nil {
+		reject(p, err.Error())
+		return
+	}
+
+	clientsMux.Lock()
+	defer clientsMux.Unlock()
+
+	client, ok := clients[clientKey]
+	if !ok || client.username == name {
+		return
+	}
+	if !nameAvailable(name, client) {
+		reject(p, "имя "+name+" уже занято")
+		return
+	}
+	renameClient(client, name)
+}
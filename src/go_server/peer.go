@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// peer — управляющий канал конкретного клиента
+type peer interface {
+	Addr() net.Addr
+	WriteFrame(data []byte) error
+	// Stream сообщает, гарантирует ли транспорт доставку и порядок сам
+	Stream() bool
+	// Close разрывает собственное соединение клиента, если оно есть
+	Close() error
+}
+
+// udpPeer — клиент на общем UDP-сокете :6000
+type udpPeer struct {
+	pc   net.PacketConn
+	addr net.Addr
+}
+
+func (p udpPeer) Addr() net.Addr { return p.addr }
+func (p udpPeer) Stream() bool   { return false }
+
+// Close ничего не делает: сокет :6000 общий для всех клиентов
+func (p udpPeer) Close() error { return nil }
+
+func (p udpPeer) WriteFrame(data []byte) error {
+	_, err := p.pc.WriteTo(data, p.addr)
+	return err
+}
+
+// streamPeer — клиент с собственным TCP/TLS-соединением
+type streamPeer struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (p *streamPeer) Addr() net.Addr { return p.conn.RemoteAddr() }
+func (p *streamPeer) Stream() bool   { return true }
+func (p *streamPeer) Close() error   { return p.conn.Close() }
+
+func (p *streamPeer) WriteFrame(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.conn.Write(data)
+	return err
+}
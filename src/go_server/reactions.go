@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"unicode"
+
+	"airchat/protocol"
+)
+
+const (
+	// maxReactionLength — длина реакции в байтах: эмодзи
+	// с модификаторами или короткое слово вроде +1
+	maxReactionLength = 32
+	// maxReactions — сколько разных реакций может быть у сообщения
+	maxReactions = 20
+)
+
+// validReaction проверяет реакцию из команды /react
+func validReaction(token string) error {
+	if token == "" {
+		return errors.New("реакция не может быть пустой")
+	}
+	if len(token) > maxReactionLength {
+		return fmt.Errorf("реакция длиннее %d байт", maxReactionLength)
+	}
+	if strings.IndexFunc(token, unicode.IsSpace) >= 0 {
+		return errors.New("реакция не может содержать пробелы")
+	}
+	return nil
+}
+
+// reactionCounts считает, сколько раз поставлена каждая реакция
+func (r historyRecord) reactionCounts() map[string]int {
+	counts := make(map[string]int, len(r.Reactions))
+	for token, who := range r.Reactions {
+		counts[token] = len(who)
+	}
+	return counts
+}
+
+// toggleReaction ставит реакцию от имени who или снимает уже
+// поставленную и возвращает изменение количества: +1 или -1.
+// Реакции копируются: прежние могут читать другие горутины.
+func (r *historyRecord) toggleReaction(token, who string) int {
+	reactions := maps.Clone(r.Reactions)
+	if reactions == nil {
+		reactions = make(map[string][]string)
+	}
+	r.Reactions = reactions
+
+	users := reactions[token]
+	i := slices.Index(users, who)
+	if i < 0 {
+		reactions[token] = append(slices.Clone(users), who)
+		return 1
+	}
+	if len(users) == 1 {
+		delete(reactions, token)
+	} else {
+		reactions[token] = slices.Delete(slices.Clone(users), i, i+1)
+	}
+	return -1
+}
+
+// handleReact ставит или снимает реакцию на сообщение: [id, реакция]
+func handleReact(p peer, msg protocol.Message) {
+	token := strings.TrimSpace(msg.Field(1))
+	if err := validReaction(token); err != nil {
+		reject(p, err.Error())
+		return
+	}
+	id, err := protocol.ParseID(msg.Field(0))
+	if err != nil {
+		reject(p, "некорректный идентификатор сообщения")
+		return
+	}
+
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+	editMu.Lock()
+	defer editMu.Unlock()
+
+	client, ok := clients[p.Addr().String()]
+	if !ok {
+		return
+	}
+	if !authorized(client) {
+		reject(p, "сервер требует входа: "+authHint)
+		return
+	}
+	r, ok := store.Message(id)
+	if !ok || r.Deleted {
+		reject(p, "сообщение "+msg.Field(0)+" не найдено")
+		return
+	}
+	if !inChannel(client, r.Channel) {
+		reject(p, "вы не состоите в канале "+r.Channel+", войдите: /join "+r.Channel)
+		return
+	}
+	if _, ok := r.Reactions[token]; !ok && len(r.Reactions) >= maxReactions {
+		reject(p, fmt.Sprintf("у сообщения уже %d разных реакций", maxReactions))
+		return
+	}
+
+	// Гость отмечается именем, участник с учётной записью — ею,
+	// чтобы реакция не менялась при смене имени
+	who := client.account
+	if who == "" {
+		who = client.username
+	}
+	delta := r.toggleReaction(token, who)
+	if err := store.UpdateReactions(r); err != nil {
+		log.Printf("❌ Ошибка записи истории: %v", err)
+		reject(p, "не удалось сохранить реакцию")
+		return
+	}
+
+	sign := "+1"
+	if delta < 0 {
+		sign = "-1"
+	}
+	channelBroadcast(r.Channel, protocol.New(protocol.TypeReact, r.Channel, protocol.FormatID(r.ID),
+		client.username, token, sign, protocol.FormatReactions(r.reactionCounts())), nil)
+}
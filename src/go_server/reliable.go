@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"airchat/protocol"
+)
+
+// deliver отправляет клиенту нумерованное сообщение, при необходимости
+// разрезав его на фрагменты; до получения ACK каждая часть остаётся
+// в очереди повторной отправки client.out
+func deliver(client *Client, msg protocol.Message) {
+	if client.peer.Stream() {
+		// Транспорт сам гарантирует доставку, порядок и границы кадров
+		data, err := fragmenter.Encode(msg)
+		if err != nil {
+			log.Printf("❌ Ошибка кодирования %s: %v", msg.Type, err)
+			return
+		}
+		// Закрытое соединение не ошибка: клиент переподключается
+		// или будет отключён по таймауту
+		if err := client.peer.WriteFrame(data); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("❌ Ошибка отправки %s для %s: %v", msg.Type, client.username, err)
+		}
+		return
+	}
+
+	parts, err := fragmenter.Split(msg)
+	if err != nil {
+		log.Printf("❌ Ошибка кодирования %s: %v", msg.Type, err)
+		return
+	}
+	for _, part := range parts {
+		data, err := client.out.Prepare(part)
+		if err != nil {
+			log.Printf("❌ Ошибка кодирования %s: %v", msg.Type, err)
+			return
+		}
+		if err := client.peer.WriteFrame(data); err != nil {
+			log.Printf("❌ Ошибка отправки %s для %s: %v", msg.Type, client.username, err)
+		}
+	}
+}
+
+// receiverFor возвращает очередь входящих сообщений сессии по адресу клиента
+func receiverFor(clientKey string) *protocol.Receiver {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	if client, ok := clients[clientKey]; ok {
+		return client.in
+	}
+	if client, ok := handshakes[clientKey]; ok {
+		return client.in
+	}
+	return nil
+}
+
+func handleAck(clientKey string, seq uint32) {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	if client, ok := clients[clientKey]; ok {
+		client.out.Ack(seq)
+	} else if client, ok := handshakes[clientKey]; ok {
+		client.out.Ack(seq)
+	}
+}
+
+// retransmitLoop периодически повторяет неподтверждённые сообщения
+// с экспоненциальной задержкой
+func retransmitLoop() {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		retransmit(now)
+	}
+}
+
+// retransmit повторяет сообщения, которым пора, и просит клиентов,
+// чьи сообщения безнадёжно потеряны, продолжить сессию заново: иначе
+// клиент ждал бы потерянный номер и не обрабатывал бы все следующие
+func retransmit(now time.Time) {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	for _, client := range clients {
+		resend, lost := client.out.Due(now)
+		for _, data := range resend {
+			client.peer.WriteFrame(data)
+		}
+		for _, msg := range lost {
+			log.Printf("❌ %s не подтвердил %s #%d", client.username, msg.Type, msg.Seq)
+		}
+		if len(lost) > 0 && !client.resync.Swap(true) {
+			send(client.peer, protocol.New(protocol.TypeResync))
+		}
+	}
+}
+
+// needsResync сообщает, ждёт ли сервер, что клиент продолжит сессию заново
+func needsResync(clientKey string) bool {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+	client, ok := clients[clientKey]
+	return ok && client.resync.Load()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"airchat/protocol"
+)
+
+func TestRetransmitGiveUpResync(t *testing.T) {
+	if fragmenter == nil {
+		fragmenter = protocol.NewFragmenter(protocol.DefaultMaxMessage)
+	}
+	old := &recordPeer{}
+	client := &Client{
+		addr:     old.Addr(),
+		peer:     old,
+		username: "alice",
+		session:  "s1",
+		out:      protocol.NewSender(),
+		in:       protocol.NewReceiver(),
+		frags:    protocol.NewReassembler(protocol.DefaultMaxMessage),
+	}
+	clientsMux.Lock()
+	clients[old.Addr().String()] = client
+	clientsMux.Unlock()
+	defer func() {
+		clientsMux.Lock()
+		clear(clients)
+		clientsMux.Unlock()
+	}()
+
+	// Клиент не подтверждает сообщение, пока сервер не сдаётся
+	clientsMux.Lock()
+	deliver(client, protocol.New(protocol.TypeNotice, "потеряно"))
+	clientsMux.Unlock()
+	now := time.Now()
+	for i := 0; i <= protocol.MaxAttempts; i++ {
+		now = now.Add(protocol.MaxRTO)
+		retransmit(now)
+	}
+	resyncs := 0
+	for _, m := range old.frames {
+		if m.Type == protocol.TypeResync {
+			resyncs++
+		}
+	}
+	if resyncs != 1 || client.out.Pending() != 0 {
+		t.Fatalf("RESYNC отправлен %d раз, в очереди %d", resyncs, client.out.Pending())
+	}
+
+	// RESYNC мог потеряться: он повторяется в ответ на PING
+	old.frames = nil
+	handleMessage(old, protocol.New(protocol.TypePing))
+	if len(old.frames) != 1 || old.frames[0].Type != protocol.TypeResync {
+		t.Fatalf("на PING получено %v, ожидался RESYNC", old.frames)
+	}
+
+	// Сообщение клиента, ждавшее пропущенный номер, не теряется при возобновлении
+	client.in.Accept(protocol.Message{Type: protocol.TypeNick, Seq: 2, Fields: []string{"bob"}})
+	fresh := &recordPeer{port: 1}
+	clientsMux.Lock()
+	resumed, backlog := resumeSession(fresh, "s1")
+	clientsMux.Unlock()
+	if resumed != client || len(backlog) != 1 || backlog[0].Field(0) != "bob" {
+		t.Fatalf("возобновлено %v, из очереди %v", resumed, backlog)
+	}
+	if client.resync.Load() {
+		t.Fatal("после возобновления клиент всё ещё ждёт RESYNC")
+	}
+
+	// Новые сообщения нумеруются заново и принимаются клиентом
+	clientsMux.Lock()
+	deliver(client, protocol.New(protocol.TypeNotice, "после"))
+	clientsMux.Unlock()
+	if len(fresh.frames) != 1 || fresh.frames[0].Seq != 1 {
+		t.Fatalf("после возобновления отправлено %v", fresh.frames)
+	}
+	in := protocol.NewReceiver()
+	if ready := in.Accept(fresh.frames[0]); len(ready) != 1 || ready[0].Field(0) != "после" {
+		t.Fatalf("клиент не принял сообщение после возобновления: %v", ready)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"airchat/protocol"
+)
+
+const (
+	// maxSearchResults — сколько найденных сообщений отправляется за раз
+	maxSearchResults = 20
+	// searchContext — сколько соседних сообщений показывается
+	// до и после найденного
+	searchContext = 1
+)
+
+// searchIndex — обратный индекс истории: слово → идентификаторы
+// сообщений, в которых оно встречается, по возрастанию. Зашифрованные
+// сообщения (E2E) не индексируются: сервер не знает их текста.
+type searchIndex struct {
+	mu    sync.RWMutex
+	terms map[string][]uint64
+}
+
+var index = &searchIndex{terms: make(map[string][]uint64)}
+
+// searchTerms разбивает текст на слова для индекса: буквы и цифры
+// в нижнем регистре, без повторов
+func searchTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	slices.Sort(words)
+	return slices.Compact(words)
+}
+
+// add добавляет сообщение в индекс. Обычно сообщения приходят
+// по возрастанию идентификаторов, но параллельные отправители
+// могут их переставить, поэтому место ищется двоичным поиском.
+func (x *searchIndex) add(r historyRecord) {
+	if r.KeyID != "" {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, t := range searchTerms(r.Text) {
+		ids := x.terms[t]
+		if i, found := slices.BinarySearch(ids, r.ID); !found {
+			x.terms[t] = slices.Insert(ids, i, r.ID)
+		}
+	}
+}
+
+// matches проверяет, что сообщение всё ещё содержит все слова: после
+// правки или удаления в индексе остаются записи о прежнем тексте
+func matches(r historyRecord, terms []string) bool {
+	if r.Deleted {
+		return false
+	}
+	words := searchTerms(r.Text)
+	for _, t := range terms {
+		if _, ok := slices.BinarySearch(words, t); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// candidates возвращает идентификаторы сообщений, содержащих все слова,
+// от новых к старым
+func (x *searchIndex) candidates(terms []string) []uint64 {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	// Перебираем самый короткий список и ищем его элементы в остальных
+	var shortest []uint64
+	for i, t := range terms {
+		ids := x.terms[t]
+		if len(ids) == 0 {
+			return nil
+		}
+		if i == 0 || len(ids) < len(shortest) {
+			shortest = ids
+		}
+	}
+	var found []uint64
+	for i := len(shortest) - 1; i >= 0; i-- {
+		id := shortest[i]
+		all := true
+		for _, t := range terms {
+			if _, ok := slices.BinarySearch(x.terms[t], id); !ok {
+				all = false
+				break
+			}
+		}
+		if all {
+			found = append(found, id)
+		}
+	}
+	return found
+}
+
+// handleSearch ищет сообщения по запросу [запрос, канал, отправитель]
+// в каналах, где состоит клиент, и отправляет найденное с контекстом
+func handleSearch(p peer, msg protocol.Message) {
+	if *chatE2E {
+		reject(p, "поиск недоступен: сообщения на сервере зашифрованы")
+		return
+	}
+	query := strings.TrimSpace(msg.Field(0))
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		reject(p, "пустой поисковый запрос")
+		return
+	}
+	from := strings.TrimSpace(msg.Field(2))
+
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	client, ok := clients[p.Addr().String()]
+	if !ok {
+		return
+	}
+
+	// Без указания канала ищем во всех каналах клиента
+	var scope func(channel string) bool
+	if msg.Field(1) != "" {
+		channel, err := memberChannel(client, msg.Field(1))
+		if err != nil {
+			reject(p, err.Error())
+			return
+		}
+		scope = func(c string) bool { return c == channel }
+	} else {
+		scope = func(c string) bool { return inChannel(client, c) }
+	}
+
+	hits := 0
+	for _, id := range index.candidates(terms) {
+		r, ok := store.Message(id)
+		if !ok || !matches(r, terms) || !scope(r.Channel) || (from != "" && !strings.EqualFold(r.Sender, from)) {
+			continue
+		}
+		sendSearchResult(client, r)
+		if hits++; hits == maxSearchResults {
+			break
+		}
+	}
+
+	switch {
+	case hits == 0:
+		deliver(client, protocol.New(protocol.TypeNotice, "По запросу «"+query+"» ничего не найдено"))
+	case hits == maxSearchResults:
+		deliver(client, protocol.New(protocol.TypeNotice,
+			"Показаны последние "+strconv.Itoa(hits)+" совпадений по запросу «"+query+"», уточните запрос"))
+	default:
+		deliver(client, protocol.New(protocol.TypeNotice,
+			"Найдено сообщений по запросу «"+query+"»: "+strconv.Itoa(hits)))
+	}
+}
+
+// sendSearchResult отправляет найденное сообщение вместе с соседними.
+// Вызывающий должен держать clientsMux.
+func sendSearchResult(client *Client, hit historyRecord) {
+	lines := store.MessagesBefore(hit.Channel, hit.ID, searchContext)
+	lines = append(lines, hit)
+	lines = append(lines, store.MessagesAfter(hit.Channel, hit.ID, searchContext)...)
+	for _, r := range lines {
+		if !r.Deleted {
+			deliver(client, protocol.SearchResult(hit.ID, r.entry()))
+		}
+	}
+}
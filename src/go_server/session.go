@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"airchat/protocol"
+)
+
+// resumeSession переносит сессию session на новое соединение p, если
+// клиент с такой сессией ещё в чате (например, его ещё не отключили
+// по таймауту). Очереди нумерованных сообщений начинаются заново,
+// голосовой чат сбрасывается: клиент подключится к нему повторно.
+// Возвращает и сообщения клиента, ждавшие в старой очереди потерянный
+// номер: их нужно обработать после WELCOME.
+// Вызывающий должен держать clientsMux.
+func resumeSession(p peer, session string) (*Client, []protocol.Message) {
+	if session == "" {
+		return nil, nil
+	}
+	for key, client := range clients {
+		if client.session != session {
+			continue
+		}
+		if key != p.Addr().String() {
+			// Старое соединение больше не нужно
+			client.peer.Close()
+		}
+		delete(clients, key)
+		leaveVoice(client)
+
+		client.addr = p.Addr()
+		client.peer = p
+		backlog := client.in.Drain()
+		client.out = protocol.NewSender()
+		client.in = protocol.NewReceiver()
+		client.resync.Store(false)
+		client.frags = protocol.NewReassembler(*maxMessage)
+		client.lastSeen.Store(time.Now().UnixNano())
+		clients[p.Addr().String()] = client
+		log.Printf("🔁 %s возобновил сессию: %s -> %s", client.username, key, p.Addr())
+		return client, backlog
+	}
+	return nil, nil
+}
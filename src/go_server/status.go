@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"airchat/protocol"
+)
+
+// statusThrottle — смены статуса одного клиента рассылаются не чаще этого;
+// смены в промежутке сливаются в одну рассылку последнего статуса
+const statusThrottle = 2 * time.Second
+
+// presenceMessage — статус клиента для рассылки: [кто, состояние, текст]
+func presenceMessage(client *Client) protocol.Message {
+	return protocol.New(protocol.TypeStatus, client.username, client.status, client.statusMsg)
+}
+
+// introducePresence сообщает новому участнику статусы тех, кто не просто
+// в сети, а остальным — что он в сети: так они забывают статус прежнего
+// владельца имени. Вызывающий должен держать clientsMux.
+func introducePresence(client *Client) {
+	if client.status == "" {
+		client.status = protocol.StatusOnline
+	}
+	for _, other := range clients {
+		if other == client {
+			continue
+		}
+		deliver(other, presenceMessage(client))
+		if other.status != protocol.StatusOnline || other.statusMsg != "" {
+			deliver(client, presenceMessage(other))
+		}
+	}
+}
+
+// handleStatus меняет статус присутствия клиента [состояние, текст]
+// и сообщает о нём всем, включая самого клиента
+func handleStatus(p peer, msg protocol.Message) {
+	state, text := msg.Field(0), strings.TrimSpace(msg.Field(1))
+	if !protocol.ValidStatus(state, text) {
+		reject(p, "некорректный статус: допустимы online, away и dnd с текстом до "+
+			strconv.Itoa(protocol.MaxStatusText)+" символов")
+		return
+	}
+
+	clientsMux.Lock()
+	defer clientsMux.Unlock()
+
+	client, ok := clients[p.Addr().String()]
+	if !ok {
+		return
+	}
+	if !authorized(client) {
+		reject(p, "сервер требует входа: "+authHint)
+		return
+	}
+	if client.status == state && client.statusMsg == text {
+		return
+	}
+	client.status, client.statusMsg = state, text
+	log.Printf("💬 %s: статус %s %s", client.username, state, text)
+
+	if client.statusPending {
+		// Рассылка уже запланирована и разошлёт последний статус
+		return
+	}
+	if wait := statusThrottle - time.Since(client.statusSent); wait > 0 {
+		client.statusPending = true
+		time.AfterFunc(wait, func() {
+			clientsMux.Lock()
+			defer clientsMux.Unlock()
+			// Клиент мог отключиться или возобновить сессию с другого адреса
+			for _, c := range clients {
+				if c == client {
+					client.statusPending = false
+					announceStatus(client)
+				}
+			}
+		})
+		return
+	}
+	announceStatus(client)
+}
+
+// announceStatus рассылает статус клиента всем, включая его самого.
+// Вызывающий должен держать clientsMux.
+func announceStatus(client *Client) {
+	client.statusSent = time.Now()
+	broadcast(presenceMessage(client), "")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// storage — долговременные данные сервера: учётные записи, каналы,
+// история сообщений и блокировки. Реализация выбирается флагом -storage.
+type storage interface {
+	accountStorage
+	channelStorage
+	historyStorage
+	banStorage
+	Close() error
+}
+
+type accountStorage interface {
+	Account(name string) (account, bool)
+	// CreateAccount возвращает ErrAccountExists, если имя уже занято
+	CreateAccount(name string, acc account) error
+}
+
+type channelStorage interface {
+	Channel(name string) (channelInfo, bool)
+	// Channels возвращает все известные каналы, упорядоченные по имени
+	Channels() []channelInfo
+	SaveChannel(ch channelInfo) error
+}
+
+type historyStorage interface {
+	// AppendMessage назначает сообщению идентификатор и время и сохраняет его
+	AppendMessage(r historyRecord) (historyRecord, error)
+	// MessagesBefore возвращает до n последних сообщений канала
+	// с идентификатором меньше before (0 — без ограничения),
+	// от старых к новым
+	MessagesBefore(channel string, before uint64, n int) []historyRecord
+	// MessagesAfter возвращает до n первых сообщений канала
+	// с идентификатором больше after, от старых к новым
+	MessagesAfter(channel string, after uint64, n int) []historyRecord
+	Message(id uint64) (historyRecord, bool)
+	// UpdateMessage заменяет сохранённое сообщение с тем же идентификатором
+	// (правка или удаление); прежний текст не должен остаться в хранилище
+	// дольше, чем до ближайшего сжатия (см. fileStore)
+	UpdateMessage(r historyRecord) error
+	// UpdateReactions сохраняет реакции на сообщение r.ID; текст
+	// сообщения при этом не меняется
+	UpdateReactions(r historyRecord) error
+	// EachMessage вызывает fn для каждого сохранённого сообщения
+	EachMessage(fn func(historyRecord))
+}
+
+type banStorage interface {
+	Ban(name string, b ban) error
+	Unban(name string) error
+	// Banned возвращает действующую блокировку имени
+	Banned(name string) (ban, bool)
+}
+
+// ErrNoMessage — сообщения с таким идентификатором нет в истории
+var ErrNoMessage = errors.New("сообщение не найдено")
+
+type account struct {
+	Hash    string    `json:"hash"` // bcrypt
+	Created time.Time `json:"created"`
+}
+
+type channelInfo struct {
+	Name    string    `json:"name"`
+	Creator string    `json:"creator"`
+	Created time.Time `json:"created"`
+}
+
+type historyRecord struct {
+	ID      uint64    `json:"id"`
+	Time    time.Time `json:"time"`
+	Channel string    `json:"channel"`
+	Sender  string    `json:"sender"`
+	Text    string    `json:"text"`
+	KeyID   string    `json:"key,omitempty"`     // непустой — текст зашифрован (E2E)
+	Account string    `json:"account,omitempty"` // учётная запись отправителя, пусто — гость
+	Session string    `json:"session,omitempty"` // сессия отправителя-гостя: только она может менять сообщение
+	Edited  bool      `json:"edited,omitempty"`
+	Deleted bool      `json:"deleted,omitempty"` // текст стёрт, осталась отметка об удалении
+	ReplyTo uint64    `json:"reply,omitempty"`   // сообщение, ответом на которое является это
+
+	// Reactions — кто поставил каждую реакцию: учётные записи или имена гостей
+	Reactions map[string][]string `json:"reactions,omitempty"`
+}
+
+type ban struct {
+	Reason  string    `json:"reason"`
+	Created time.Time `json:"created"`
+	Until   time.Time `json:"until,omitempty"` // нулевое — бессрочно
+}
+
+// active сообщает, действует ли блокировка в момент now
+func (b ban) active(now time.Time) bool {
+	return b.Until.IsZero() || now.Before(b.Until)
+}
+
+// store — хранилище, открытое при запуске
+var store storage
+
+// openStorage открывает хранилище вида kind: "memory" или "file"
+// (данные в каталоге dir)
+func openStorage(kind, dir string) (storage, error) {
+	switch kind {
+	case "memory":
+		return newMemoryStore(), nil
+	case "file":
+		return openFileStore(dir)
+	}
+	return nil, fmt.Errorf("неизвестное хранилище %q (допустимо file или memory)", kind)
+}
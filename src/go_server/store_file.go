@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// fileStore хранит данные в каталоге:
+//
+//	accounts.json — учётные записи
+//	channels.json — каналы
+//	bans.json     — блокировки имён; их задаёт администратор, правя
+//	                файл при остановленном сервере
+//	history.jsonl — история сообщений, по строке JSON на сообщение
+//
+// Таблицы держатся в памяти (как в memoryStore) и при каждом изменении
+// атомарно перезаписываются целиком. История только дописывается, поэтому
+// запись не может повредить уже сохранённые сообщения: правка, удаление
+// и реакции дописывают новую версию сообщения, при загрузке последняя
+// версия заменяет предыдущие. Устаревшие версии (а с ними прежний текст
+// изменённых и удалённых сообщений) стираются сжатием истории — при запуске
+// и когда их накапливается больше половины числа сообщений.
+type fileStore struct {
+	*memoryStore
+	dir string
+
+	mu         sync.Mutex // упорядочивает изменения и запись файлов
+	historyLog *os.File
+	stale      int // строк истории, заменённых более поздними версиями
+}
+
+// minCompact — меньше стольких устаревших строк история не сжимается
+const minCompact = 256
+
+func openFileStore(dir string) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	s := &fileStore{memoryStore: newMemoryStore(), dir: dir}
+	if err := loadJSON(s.path("accounts.json"), &s.accounts); err != nil {
+		return nil, err
+	}
+	s.accounts = normalizeAccounts(s.accounts)
+	if err := loadJSON(s.path("channels.json"), &s.channels); err != nil {
+		return nil, err
+	}
+	if err := loadJSON(s.path("bans.json"), &s.bans); err != nil {
+		return nil, err
+	}
+	if err := s.loadHistory(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	s.historyLog, err = os.OpenFile(s.path("history.jsonl"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if s.stale > 0 {
+		if err := s.compact(); err != nil {
+			s.historyLog.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *fileStore) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+func (s *fileStore) loadHistory() error {
+	f, err := os.Open(s.path("history.jsonl"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var r historyRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// Обрыв при записи портит только последнюю строку
+			log.Printf("⚠️ Пропущена повреждённая запись истории (строка %d): %v", line, err)
+			continue
+		}
+		if _, ok := s.byID[r.ID]; ok {
+			s.stale++
+		}
+		s.appendLocked(r)
+	}
+	return scanner.Err()
+}
+
+// Изменения сначала записываются в файл и только затем применяются
+// в памяти, чтобы при ошибке записи память не расходилась с диском
+
+func (s *fileStore) CreateAccount(name string, acc account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accounts := s.accountsSnapshot()
+	if _, ok := accounts[name]; ok {
+		return ErrAccountExists
+	}
+	accounts[name] = acc
+	if err := saveJSON(s.path("accounts.json"), accounts); err != nil {
+		return err
+	}
+	return s.memoryStore.CreateAccount(name, acc)
+}
+
+func (s *fileStore) SaveChannel(ch channelInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	channels := s.channelsSnapshot()
+	channels[ch.Name] = ch
+	if err := saveJSON(s.path("channels.json"), channels); err != nil {
+		return err
+	}
+	return s.memoryStore.SaveChannel(ch)
+}
+
+func (s *fileStore) AppendMessage(r historyRecord) (historyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.memoryStore.mu.Lock()
+	r.ID = s.lastID + 1
+	s.memoryStore.mu.Unlock()
+	r.Time = time.Now().UTC()
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return historyRecord{}, err
+	}
+	if _, err := s.historyLog.Write(append(data, '\n')); err != nil {
+		return historyRecord{}, err
+	}
+
+	s.memoryStore.mu.Lock()
+	s.appendLocked(r)
+	s.memoryStore.mu.Unlock()
+	return r, nil
+}
+
+func (s *fileStore) UpdateMessage(r historyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.Message(r.ID); !ok {
+		return ErrNoMessage
+	}
+	if err := s.appendVersion(r); err != nil {
+		return err
+	}
+	if err := s.memoryStore.UpdateMessage(r); err != nil {
+		return err
+	}
+	s.compactIfStale()
+	return nil
+}
+
+// UpdateReactions дописывает в историю новую версию сообщения: при загрузке
+// последняя запись с тем же идентификатором заменяет предыдущие
+func (s *fileStore) UpdateReactions(r historyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.Message(r.ID)
+	if !ok {
+		return ErrNoMessage
+	}
+	old.Reactions = r.Reactions
+	if err := s.appendVersion(old); err != nil {
+		return err
+	}
+	if err := s.memoryStore.UpdateReactions(old); err != nil {
+		return err
+	}
+	s.compactIfStale()
+	return nil
+}
+
+// appendVersion дописывает новую версию сохранённого сообщения.
+// Вызывающий должен держать s.mu.
+func (s *fileStore) appendVersion(r historyRecord) error {
+	r.Channel = s.memoryStore.messageChannel(r.ID)
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := s.historyLog.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	s.stale++
+	return nil
+}
+
+// compactIfStale сжимает историю, если устаревших версий стало больше
+// половины числа сообщений: так сжатие обходится в среднем в несколько
+// строк на изменение. Ошибка сжатия не теряет данных — файл остаётся
+// прежним. Вызывающий должен держать s.mu.
+func (s *fileStore) compactIfStale() {
+	if s.stale < minCompact || s.stale*2 < s.messageCount() {
+		return
+	}
+	if err := s.compact(); err != nil {
+		log.Printf("❌ Ошибка сжатия истории: %v", err)
+	}
+}
+
+// compact перезаписывает историю, оставляя по одной, последней версии
+// каждого сообщения. Вызывающий должен держать s.mu.
+func (s *fileStore) compact() error {
+	var records []historyRecord
+	s.EachMessage(func(r historyRecord) {
+		records = append(records, r)
+	})
+	slices.SortFunc(records, func(a, b historyRecord) int { return cmp.Compare(a.ID, b.ID) })
+	if err := s.rewriteHistory(records); err != nil {
+		return err
+	}
+	s.stale = 0
+	return nil
+}
+
+// rewriteHistory атомарно заменяет файл истории и заново открывает
+// его для дописывания. Вызывающий должен держать s.mu.
+func (s *fileStore) rewriteHistory(records []historyRecord) error {
+	path := s.path("history.jsonl")
+	tmp, err := os.CreateTemp(s.dir, ".history.jsonl-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	s.historyLog.Close()
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		s.historyLog, _ = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		return err
+	}
+	s.historyLog, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	return err
+}
+
+func (s *fileStore) Ban(name string, b ban) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bans := s.bansSnapshot()
+	bans[banKey(name)] = b
+	if err := saveJSON(s.path("bans.json"), bans); err != nil {
+		return err
+	}
+	return s.memoryStore.Ban(name, b)
+}
+
+func (s *fileStore) Unban(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bans := s.bansSnapshot()
+	delete(bans, banKey(name))
+	if err := saveJSON(s.path("bans.json"), bans); err != nil {
+		return err
+	}
+	return s.memoryStore.Unban(name)
+}
+
+func (s *fileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.historyLog.Close()
+}
+
+// loadJSON читает JSON-файл в v; отсутствие файла не ошибка
+func loadJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSON атомарно перезаписывает файл: пишет во временный файл
+// рядом и переименовывает его
+func saveJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
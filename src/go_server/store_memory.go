@@ -0,0 +1,246 @@
+package main
+
+import (
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore хранит всё в памяти; данные теряются при выходе.
+// Подходит для временных серверов и тестов, а также служит основой fileStore.
+type memoryStore struct {
+	mu       sync.Mutex
+	accounts map[string]account
+	channels map[string]channelInfo
+	bans     map[string]ban // по banKey
+	lastID   uint64
+	history  map[string][]historyRecord // по каналу, идентификаторы возрастают
+	byID     map[uint64]string          // канал сообщения по идентификатору
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		accounts: make(map[string]account),
+		channels: make(map[string]channelInfo),
+		bans:     make(map[string]ban),
+		history:  make(map[string][]historyRecord),
+		byID:     make(map[uint64]string),
+	}
+}
+
+func (s *memoryStore) Account(name string) (account, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	acc, ok := s.accounts[name]
+	return acc, ok
+}
+
+func (s *memoryStore) CreateAccount(name string, acc account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.accounts[name]; ok {
+		return ErrAccountExists
+	}
+	s.accounts[name] = acc
+	return nil
+}
+
+func (s *memoryStore) Channel(name string) (channelInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch, ok := s.channels[name]
+	return ch, ok
+}
+
+func (s *memoryStore) Channels() []channelInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]channelInfo, 0, len(s.channels))
+	for _, ch := range s.channels {
+		list = append(list, ch)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (s *memoryStore) SaveChannel(ch channelInfo) error {
+	s.mu.Lock()
+	s.channels[ch.Name] = ch
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStore) AppendMessage(r historyRecord) (historyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.ID = s.lastID + 1
+	r.Time = time.Now().UTC()
+	s.appendLocked(r)
+	return r, nil
+}
+
+// appendLocked добавляет сообщение с уже назначенным идентификатором.
+// Вызывающий должен держать s.mu.
+func (s *memoryStore) appendLocked(r historyRecord) {
+	if _, ok := s.byID[r.ID]; ok {
+		s.replaceLocked(r)
+		return
+	}
+	s.history[r.Channel] = append(s.history[r.Channel], r)
+	s.byID[r.ID] = r.Channel
+	if r.ID > s.lastID {
+		s.lastID = r.ID
+	}
+}
+
+func (s *memoryStore) MessagesBefore(channel string, before uint64, n int) []historyRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := s.history[channel]
+	end := len(records)
+	if before != 0 {
+		end = sort.Search(len(records), func(i int) bool { return records[i].ID >= before })
+	}
+	start := max(end-n, 0)
+	return append([]historyRecord(nil), records[start:end]...)
+}
+
+func (s *memoryStore) MessagesAfter(channel string, after uint64, n int) []historyRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := s.history[channel]
+	start := sort.Search(len(records), func(i int) bool { return records[i].ID > after })
+	end := min(start+n, len(records))
+	return append([]historyRecord(nil), records[start:end]...)
+}
+
+func (s *memoryStore) Message(id uint64) (historyRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.messageLocked(id)
+}
+
+// messageLocked ищет сообщение по идентификатору.
+// Вызывающий должен держать s.mu.
+func (s *memoryStore) messageLocked(id uint64) (historyRecord, bool) {
+	channel, ok := s.byID[id]
+	if !ok {
+		return historyRecord{}, false
+	}
+	records := s.history[channel]
+	i := sort.Search(len(records), func(i int) bool { return records[i].ID >= id })
+	return records[i], true
+}
+
+func (s *memoryStore) UpdateMessage(r historyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byID[r.ID]; !ok {
+		return ErrNoMessage
+	}
+	s.replaceLocked(r)
+	return nil
+}
+
+func (s *memoryStore) UpdateReactions(r historyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.messageLocked(r.ID)
+	if !ok {
+		return ErrNoMessage
+	}
+	old.Reactions = r.Reactions
+	s.replaceLocked(old)
+	return nil
+}
+
+// messageChannel возвращает канал сообщения id
+func (s *memoryStore) messageChannel(id uint64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.byID[id]
+}
+
+// messageCount возвращает число сохранённых сообщений
+func (s *memoryStore) messageCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.byID)
+}
+
+// replaceLocked заменяет сообщение с идентификатором r.ID; канал
+// сообщения не меняется. Вызывающий должен держать s.mu.
+func (s *memoryStore) replaceLocked(r historyRecord) {
+	r.Channel = s.byID[r.ID]
+	records := s.history[r.Channel]
+	i := sort.Search(len(records), func(i int) bool { return records[i].ID >= r.ID })
+	records[i] = r
+}
+
+func (s *memoryStore) EachMessage(fn func(historyRecord)) {
+	s.mu.Lock()
+	var all []historyRecord
+	for _, records := range s.history {
+		all = append(all, records...)
+	}
+	s.mu.Unlock()
+
+	// fn вызывается без блокировки: ей можно обращаться к хранилищу
+	for _, r := range all {
+		fn(r)
+	}
+}
+
+func (s *memoryStore) Ban(name string, b ban) error {
+	s.mu.Lock()
+	s.bans[banKey(name)] = b
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStore) Unban(name string) error {
+	s.mu.Lock()
+	delete(s.bans, banKey(name))
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStore) Banned(name string) (ban, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.bans[banKey(name)]
+	return b, ok && b.active(time.Now())
+}
+
+// banKey — ключ блокировки: имена сравниваются без учёта регистра
+func banKey(name string) string {
+	return strings.ToLower(name)
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// Снимки таблиц для сохранения в файлы
+
+func (s *memoryStore) accountsSnapshot() map[string]account {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.accounts)
+}
+
+func (s *memoryStore) channelsSnapshot() map[string]channelInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.channels)
+}
+
+func (s *memoryStore) bansSnapshot() map[string]ban {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.bans)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// backends — хранилища, которые должны вести себя одинаково
+func backends(t *testing.T) map[string]storage {
+	fs, err := openFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return map[string]storage{"memory": newMemoryStore(), "file": fs}
+}
+
+func TestStoreHistory(t *testing.T) {
+	for kind, s := range backends(t) {
+		t.Run(kind, func(t *testing.T) {
+			var ids []uint64
+			for _, ch := range []string{"#general", "#go", "#general"} {
+				r, err := s.AppendMessage(historyRecord{Channel: ch, Sender: "alice", Text: "привет " + ch})
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, r.ID)
+			}
+			if !slices.Equal(ids, []uint64{1, 2, 3}) {
+				t.Fatalf("идентификаторы %v", ids)
+			}
+
+			tests := []struct {
+				name string
+				got  []historyRecord
+				want []uint64
+			}{
+				{"последние", s.MessagesBefore("#general", 0, 10), []uint64{1, 3}},
+				{"до id", s.MessagesBefore("#general", 3, 10), []uint64{1}},
+				{"ограничение", s.MessagesBefore("#general", 0, 1), []uint64{3}},
+				{"после id", s.MessagesAfter("#general", 1, 10), []uint64{3}},
+				{"другой канал", s.MessagesAfter("#go", 0, 10), []uint64{2}},
+				{"нет канала", s.MessagesBefore("#none", 0, 10), nil},
+			}
+			for _, tt := range tests {
+				var got []uint64
+				for _, r := range tt.got {
+					got = append(got, r.ID)
+				}
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("%s: %v, ожидалось %v", tt.name, got, tt.want)
+				}
+			}
+
+			if err := s.UpdateMessage(historyRecord{ID: 99}); !errors.Is(err, ErrNoMessage) {
+				t.Errorf("правка несуществующего сообщения: %v", err)
+			}
+			if err := s.UpdateReactions(historyRecord{ID: 99}); !errors.Is(err, ErrNoMessage) {
+				t.Errorf("реакция на несуществующее сообщение: %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreBans(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		until  time.Time
+		banned bool
+	}{
+		{"бессрочно", time.Time{}, true},
+		{"действует", now.Add(time.Hour), true},
+		{"истекла", now.Add(-time.Second), false},
+	}
+	for kind, s := range backends(t) {
+		for _, tt := range tests {
+			t.Run(kind+"/"+tt.name, func(t *testing.T) {
+				if err := s.Ban("Bob", ban{Reason: "спам", Created: now, Until: tt.until}); err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := s.Banned("BOB"); ok != tt.banned {
+					t.Fatalf("заблокирован %v, ожидалось %v", ok, tt.banned)
+				}
+				if err := s.Unban("bob"); err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := s.Banned("Bob"); ok {
+					t.Fatal("блокировка не снята")
+				}
+			})
+		}
+	}
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Now().UTC().Truncate(time.Second)
+	if err := s.CreateAccount("alice", account{Hash: "h", Created: created}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateAccount("alice", account{Hash: "x"}); !errors.Is(err, ErrAccountExists) {
+		t.Fatalf("повторная учётная запись: %v", err)
+	}
+	if err := s.SaveChannel(channelInfo{Name: "#go", Creator: "alice", Created: created}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Ban("mallory", ban{Reason: "спам", Created: created}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.AppendMessage(historyRecord{Channel: "#go", Sender: "alice", Text: "черновик", Account: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.AppendMessage(historyRecord{Channel: "#go", Sender: "bob", Text: "ответ", Session: "s1", ReplyTo: first.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := first
+	edited.Text, edited.Edited = "исправлено", true
+	if err := s.UpdateMessage(edited); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateReactions(historyRecord{ID: second.ID, Reactions: map[string][]string{"👍": {"alice"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Каждое изменение дописало строку: 2 сообщения и 2 новые версии
+	if n := countLines(t, filepath.Join(dir, "history.jsonl")); n != 4 {
+		t.Fatalf("строк истории до сжатия %d, ожидалось 4", n)
+	}
+
+	s, err = openFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// При открытии устаревшие версии стираются
+	if n := countLines(t, filepath.Join(dir, "history.jsonl")); n != 2 {
+		t.Fatalf("строк истории после сжатия %d, ожидалось 2", n)
+	}
+
+	if acc, ok := s.Account("alice"); !ok || acc.Hash != "h" || !acc.Created.Equal(created) {
+		t.Errorf("учётная запись: %+v %v", acc, ok)
+	}
+	if ch, ok := s.Channel("#go"); !ok || ch.Creator != "alice" {
+		t.Errorf("канал: %+v %v", ch, ok)
+	}
+	if b, ok := s.Banned("Mallory"); !ok || b.Reason != "спам" {
+		t.Errorf("блокировка: %+v %v", b, ok)
+	}
+
+	got := s.MessagesBefore("#go", 0, 10)
+	if len(got) != 2 {
+		t.Fatalf("сообщений %d, ожидалось 2", len(got))
+	}
+	if r := got[0]; r.Text != "исправлено" || !r.Edited || r.Account != "alice" || r.Channel != "#go" {
+		t.Errorf("правка не сохранилась: %+v", r)
+	}
+	if r := got[1]; r.Text != "ответ" || r.Session != "s1" || r.ReplyTo != first.ID ||
+		!slices.Equal(r.Reactions["👍"], []string{"alice"}) {
+		t.Errorf("реакция не сохранилась: %+v", r)
+	}
+
+	// Новые сообщения продолжают нумерацию
+	r, err := s.AppendMessage(historyRecord{Channel: "#go", Sender: "alice", Text: "ещё"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ID != second.ID+1 {
+		t.Errorf("идентификатор после открытия %d, ожидался %d", r.ID, second.ID+1)
+	}
+}
+
+func TestFileStoreCompactReactions(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	r, err := s.AppendMessage(historyRecord{Channel: "#general", Sender: "alice", Text: "голосуем"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Реакцию ставят и снимают много раз: файл не должен расти без предела
+	for i := 0; i < minCompact*2; i++ {
+		reactions := map[string][]string{"👍": {"bob"}}
+		if i%2 == 1 {
+			reactions = nil
+		}
+		if err := s.UpdateReactions(historyRecord{ID: r.ID, Reactions: reactions}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countLines(t, filepath.Join(dir, "history.jsonl")); n > minCompact+1 {
+		t.Fatalf("строк истории %d, история не сжимается", n)
+	}
+	if got, _ := s.Message(r.ID); len(got.Reactions) != 0 || got.Text != "голосуем" {
+		t.Fatalf("после сжатия: %+v", got)
+	}
+}
+
+func TestNormalizeAccounts(t *testing.T) {
+	early := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	late := early.Add(time.Hour)
+	got := normalizeAccounts(map[string]account{
+		"Alice": {Hash: "late", Created: late},
+		"alice": {Hash: "early", Created: early},
+		"Bob":   {Hash: "bob", Created: late},
+	})
+	want := map[string]string{"alice": "early", "bob": "bob"}
+	if len(got) != len(want) {
+		t.Fatalf("учётных записей %d, ожидалось %d: %v", len(got), len(want), got)
+	}
+	for name, hash := range want {
+		if got[name].Hash != hash {
+			t.Errorf("%s: %q, ожидалось %q", name, got[name].Hash, hash)
+		}
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		n++
+	}
+	return n
+}
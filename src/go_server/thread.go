@@ -0,0 +1,148 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+
+	"airchat/protocol"
+)
+
+const (
+	// maxThreadMessages — сколько сообщений обсуждения отправляется за раз
+	maxThreadMessages = 200
+	// maxThreadDepth ограничивает подъём к корню обсуждения
+	maxThreadDepth = 1000
+)
+
+// replyIndex — ответы на каждое сообщение по возрастанию идентификаторов
+type replyIndex struct {
+	mu      sync.RWMutex
+	replies map[uint64][]uint64
+}
+
+var replies = &replyIndex{replies: make(map[uint64][]uint64)}
+
+func (x *replyIndex) add(r historyRecord) {
+	if r.ReplyTo == 0 {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	ids := x.replies[r.ReplyTo]
+	if i, found := slices.BinarySearch(ids, r.ID); !found {
+		x.replies[r.ReplyTo] = slices.Insert(ids, i, r.ID)
+	}
+}
+
+func (x *replyIndex) of(id uint64) []uint64 {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return slices.Clone(x.replies[id])
+}
+
+// excerptOf возвращает начало сообщения id для показа рядом с ответом
+func excerptOf(id uint64) protocol.Excerpt {
+	if id == 0 {
+		return protocol.Excerpt{}
+	}
+	x := protocol.Excerpt{ID: id}
+	r, ok := store.Message(id)
+	if !ok {
+		return x
+	}
+	x.Sender, x.Text, x.KeyID = r.Sender, r.Text, r.KeyID
+	if r.KeyID == "" {
+		x.Text = protocol.Shorten(r.Text, protocol.ExcerptLength)
+	}
+	return x
+}
+
+// replyChannel определяет канал нового сообщения. Ответ попадает
+// в канал родительского сообщения, а не в указанный клиентом.
+// Возвращает канал и идентификатор родителя (0 — не ответ).
+// Вызывающий должен держать clientsMux.
+func replyChannel(client *Client, channel, replyTo string) (string, uint64, error) {
+	if replyTo == "" {
+		channel, err := memberChannel(client, channel)
+		return channel, 0, err
+	}
+	id, err := protocol.ParseID(replyTo)
+	if err != nil {
+		return "", 0, errors.New("некорректный идентификатор сообщения")
+	}
+	parent, ok := store.Message(id)
+	if !ok || parent.Deleted {
+		return "", 0, errors.New("сообщение " + replyTo + " не найдено")
+	}
+	channel, err = memberChannel(client, parent.Channel)
+	return channel, id, err
+}
+
+// threadRoot поднимается от сообщения к началу обсуждения
+func threadRoot(r historyRecord) historyRecord {
+	for i := 0; r.ReplyTo != 0 && i < maxThreadDepth; i++ {
+		parent, ok := store.Message(r.ReplyTo)
+		if !ok {
+			break
+		}
+		r = parent
+	}
+	return r
+}
+
+// handleThread отправляет обсуждение, в которое входит сообщение: [id].
+// Обсуждение — начальное сообщение и все ответы на него и на ответы.
+func handleThread(p peer, msg protocol.Message) {
+	id, err := protocol.ParseID(msg.Field(0))
+	if err != nil {
+		reject(p, "некорректный идентификатор сообщения")
+		return
+	}
+
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	client, ok := clients[p.Addr().String()]
+	if !ok {
+		return
+	}
+	r, ok := store.Message(id)
+	if !ok {
+		reject(p, "сообщение "+msg.Field(0)+" не найдено")
+		return
+	}
+	if !inChannel(client, r.Channel) {
+		reject(p, "вы не состоите в канале "+r.Channel+", войдите: /join "+r.Channel)
+		return
+	}
+
+	root := threadRoot(r)
+	thread := []historyRecord{root}
+	for i := 0; i < len(thread) && len(thread) < maxThreadMessages; i++ {
+		for _, child := range replies.of(thread[i].ID) {
+			if c, ok := store.Message(child); ok {
+				thread = append(thread, c)
+			}
+		}
+	}
+	truncated := len(thread) >= maxThreadMessages
+	thread = thread[:min(len(thread), maxThreadMessages)]
+	slices.SortFunc(thread, func(a, b historyRecord) int { return cmp.Compare(a.ID, b.ID) })
+
+	// Удалённые сообщения не показываются, но их ответы остаются в обсуждении
+	shown := 0
+	for _, t := range thread {
+		if !t.Deleted {
+			deliver(client, protocol.ThreadEntry(root.ID, t.entry()))
+			shown++
+		}
+	}
+	text := "В обсуждении сообщений: " + strconv.Itoa(shown)
+	if truncated {
+		text = "Показаны первые " + strconv.Itoa(maxThreadMessages) + " сообщений обсуждения"
+	}
+	deliver(client, protocol.New(protocol.TypeNotice, text))
+}
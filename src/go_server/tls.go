@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"time"
+
+	"airchat/protocol"
+)
+
+// loadCertificate загружает сертификат из файлов или, если они не заданы,
+// создаёт самоподписанный на время работы сервера
+func loadCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile != "" || keyFile != "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "AirChat"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// certFingerprint возвращает SHA-256 отпечаток листового сертификата,
+// по которому клиент может закрепить самоподписанный сертификат (-tls-pin)
+func certFingerprint(cert tls.Certificate) string {
+	sum := sha256.Sum256(cert.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
+
+// serveTLS принимает управляющие соединения по TLS поверх TCP
+func serveTLS(addr string, cert tls.Certificate) error {
+	ln, err := tls.Listen("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("TLS-сервер запущен на %s (отпечаток сертификата %s)", addr, certFingerprint(cert))
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				log.Printf("Ошибка приёма TLS-соединения: %v", err)
+				continue
+			}
+			go handleStream(conn)
+		}
+	}()
+	return nil
+}
+
+// handleStream читает кадры одного TCP/TLS-клиента до разрыва соединения
+func handleStream(conn net.Conn) {
+	p := &streamPeer{conn: conn}
+	clientKey := conn.RemoteAddr().String()
+	defer func() {
+		conn.Close()
+		// Сессия вошедшего клиента сохраняется до таймаута, чтобы он мог
+		// продолжить её после переподключения; при выходе клиент шлёт LEAVE
+		clientsMux.Lock()
+		delete(handshakes, clientKey)
+		clientsMux.Unlock()
+	}()
+
+	for {
+		msg, err := protocol.ReadFrame(conn)
+		if err != nil {
+			var verr *protocol.VersionError
+			if errors.As(err, &verr) {
+				reject(p, fmt.Sprintf("несовместимая версия протокола: клиент %d, сервер %d",
+					verr.Got, protocol.Version))
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("❌ Ошибка чтения от %s: %v", clientKey, err)
+			}
+			return
+		}
+		if checkSize(p, msg) {
+			handleMessage(p, msg)
+		}
+	}
+}
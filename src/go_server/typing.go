@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"airchat/protocol"
+)
+
+const (
+	// typingTTL — сколько клиенты показывают «печатает…» после сигнала;
+	// пока пользователь набирает текст, клиент повторяет сигнал чаще
+	typingTTL = 6 * time.Second
+	// typingThrottle — сигналы одного клиента рассылаются не чаще этого
+	typingThrottle = time.Second
+)
+
+// handleTyping сообщает участникам канала, что клиент набирает сообщение: [канал].
+// Сигнал не подтверждается и не сохраняется: потерянный или отброшенный
+// TYPING лишь ненадолго скрывает подсказку, поэтому ошибки не отправляются.
+func handleTyping(p peer, msg protocol.Message) {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	client, ok := clients[p.Addr().String()]
+	if !ok || !authorized(client) {
+		return
+	}
+	channel, err := memberChannel(client, msg.Field(0))
+	if err != nil {
+		return
+	}
+	now := time.Now().UnixNano()
+	if now-client.lastTyping.Load() < int64(typingThrottle) {
+		return
+	}
+	client.lastTyping.Store(now)
+
+	typing := protocol.New(protocol.TypeTyping, channel, client.username,
+		strconv.Itoa(int(typingTTL/time.Second)))
+	for member := range channels[channel].members {
+		if member != client {
+			send(member.peer, typing)
+		}
+	}
+}
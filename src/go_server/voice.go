@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"airchat/protocol"
+)
+
+var (
+	// voicePriv — ключ сервера для передачи ключей голоса клиентам
+	voicePriv *ecdh.PrivateKey
+	// voiceOpener проверяет голосовые пакеты в режиме VoiceModeServer
+	voiceOpener = protocol.NewVoiceOpener()
+)
+
+func initVoiceCrypto() error {
+	var err error
+	voicePriv, err = protocol.NewVoiceKeyPair()
+	return err
+}
+
+func voiceMode() string {
+	if *voiceE2E {
+		return protocol.VoiceModeE2E
+	}
+	return protocol.VoiceModeServer
+}
+
+// voiceSender ищет участника голосового чата по ssrc.
+// Вызывающий должен держать clientsMux.
+func voiceSender(ssrc uint32) (*Client, bool) {
+	for _, client := range clients {
+		if client.inVoice && client.voiceSSRC == ssrc {
+			return client, true
+		}
+	}
+	return nil, false
+}
+
+// newSSRC выбирает свободный ненулевой идентификатор отправителя.
+// Вызывающий должен держать clientsMux.
+func newSSRC() uint32 {
+	b := make([]byte, 4)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			log.Fatalf("Ошибка генерации ssrc: %v", err)
+		}
+		ssrc := binary.BigEndian.Uint32(b)
+		if _, used := voiceSender(ssrc); ssrc != 0 && !used {
+			return ssrc
+		}
+	}
+}
+
+// joinVoice подключает клиента к голосовому каналу: выдаёт ssrc,
+// согласует ключи голоса и знакомит его с участниками того же канала.
+// Вызывающий должен держать clientsMux.
+func joinVoice(client *Client, channel string, pub []byte) error {
+	if !contains(client.features, protocol.FeatureVoice) {
+		return errors.New("голосовой чат не согласован при рукопожатии")
+	}
+	if *voiceE2E && !contains(client.features, protocol.FeatureVoiceE2E) {
+		return errors.New("сервер требует сквозного шифрования голоса")
+	}
+	if *voiceE2E && len(client.identity) == 0 {
+		// Ключами идентичности участники заверяют друг другу ключи голоса
+		return errors.New("для сквозного шифрования голоса нужен ключ идентичности")
+	}
+	if _, err := ecdh.X25519().NewPublicKey(pub); err != nil {
+		return errors.New("некорректный ключ голосового чата")
+	}
+	leaveVoice(client)
+
+	client.voiceSSRC = newSSRC()
+	client.voicePub = pub
+	client.voiceChan = channel
+	accept := protocol.New(protocol.TypeVoiceAccept,
+		strconv.FormatUint(uint64(client.voiceSSRC), 10), voiceMode(), "", "", channel)
+
+	if !*voiceE2E {
+		key, err := protocol.NewVoiceKey()
+		if err != nil {
+			return err
+		}
+		sealed, err := protocol.SealVoiceKey(voicePriv, pub, key)
+		if err != nil {
+			return err
+		}
+		client.voiceKey = key
+		voiceOpener.SetKey(client.voiceSSRC, key)
+		accept.Fields[2] = string(voicePriv.PublicKey().Bytes())
+		accept.Fields[3] = string(sealed)
+	}
+	deliver(client, accept)
+
+	for _, other := range clients {
+		if !other.inVoice || other == client || other.voiceChan != channel {
+			continue
+		}
+		deliver(other, voicePeerMessage(client))
+		deliver(client, voicePeerMessage(other))
+
+		// В режиме сервера ключи раздаёт сервер; в E2E клиенты
+		// обменяются ими сами по открытым ключам из VOICE_PEER,
+		// заверив их ключами идентичности
+		if !*voiceE2E {
+			shareVoiceKey(other, client)
+			shareVoiceKey(client, other)
+		}
+	}
+	client.inVoice = true
+	return nil
+}
+
+func voicePeerMessage(client *Client) protocol.Message {
+	return protocol.New(protocol.TypeVoicePeer,
+		strconv.FormatUint(uint64(client.voiceSSRC), 10), client.username, string(client.voicePub))
+}
+
+// shareVoiceKey передаёт to ключ голоса from, зашифрованный для to
+func shareVoiceKey(to, from *Client) {
+	sealed, err := protocol.SealVoiceKey(voicePriv, to.voicePub, from.voiceKey)
+	if err != nil {
+		log.Printf("❌ Ошибка шифрования ключа голоса для %s: %v", to.username, err)
+		return
+	}
+	deliver(to, protocol.New(protocol.TypeVoiceKey,
+		strconv.FormatUint(uint64(from.voiceSSRC), 10), string(sealed)))
+}
+
+// leaveVoice убирает клиента из голосового канала и сообщает остальным
+// участникам канала, что его ключ больше не действует.
+// Вызывающий должен держать clientsMux.
+func leaveVoice(client *Client) {
+	if !client.inVoice {
+		return
+	}
+	client.inVoice = false
+	voiceOpener.Remove(client.voiceSSRC)
+
+	left := protocol.New(protocol.TypeVoicePeerLeft, strconv.FormatUint(uint64(client.voiceSSRC), 10))
+	for _, other := range clients {
+		if other.inVoice && other.voiceChan == client.voiceChan {
+			deliver(other, left)
+		}
+	}
+	client.voiceChan = ""
+	client.voiceSSRC = 0
+	client.voiceAddr = ""
+	client.voicePub = nil
+	client.voiceKey = nil
+}
+
+// handleVoiceKey пересылает ключ голоса одного участника другому
+// в режиме E2E: [ssrc получателя, ключ] -> [ssrc отправителя, ключ]
+func handleVoiceKey(clientKey string, msg protocol.Message) {
+	if !*voiceE2E {
+		return
+	}
+	ssrc, err := strconv.ParseUint(msg.Field(0), 10, 32)
+	if err != nil {
+		return
+	}
+
+	clientsMux.Lock()
+	defer clientsMux.Unlock()
+
+	sender, ok := clients[clientKey]
+	if !ok || !sender.inVoice {
+		return
+	}
+	if recipient, ok := voiceSender(uint32(ssrc)); ok && recipient.voiceChan == sender.voiceChan {
+		deliver(recipient, protocol.New(protocol.TypeVoiceKey,
+			strconv.FormatUint(uint64(sender.voiceSSRC), 10), msg.Field(1)))
+	}
+}
+
+// handleVoiceList отвечает списком голосовых каналов с их участниками
+func handleVoiceList(p peer) {
+	clientsMux.RLock()
+	defer clientsMux.RUnlock()
+
+	client, ok := clients[p.Addr().String()]
+	if !ok {
+		return
+	}
+	members := make(map[string][]string)
+	for _, c := range clients {
+		if c.inVoice {
+			members[c.voiceChan] = append(members[c.voiceChan], c.username)
+		}
+	}
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		sort.Strings(members[name])
+		fields = append(fields, name, strings.Join(members[name], " "))
+	}
+	deliver(client, protocol.New(protocol.TypeVoiceList, fields...))
+}
+
+// verifyVoicePacket проверяет, что пакет действительно от sender,
+// и при необходимости обновляет его голосовой адрес.
+// Вызывающий должен держать clientsMux.
+func verifyVoicePacket(sender *Client, packet []byte, remoteAddr net.Addr) bool {
+	if !*voiceE2E {
+		// Подделать пакет без ключа нельзя, поэтому адрес можно
+		// обновлять по любому успешно проверенному пакету
+		if _, _, err := voiceOpener.Open(nil, packet); err != nil {
+			return false
+		}
+	} else if sender.voiceAddr != remoteAddr.String() &&
+		strings.Split(sender.addr.String(), ":")[0] != strings.Split(remoteAddr.String(), ":")[0] {
+		// Сервер не может проверить пакет, поэтому доверяет только IP,
+		// с которого клиент подключён к чату
+		return false
+	}
+
+	if sender.voiceAddr != remoteAddr.String() {
+		log.Printf("Обновление voiceAddr для %s: %s -> %s", sender.username, sender.voiceAddr, remoteAddr.String())
+		sender.voiceAddr = remoteAddr.String()
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}